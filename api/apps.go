@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/kraudcloud/cli/compose/envparser"
@@ -150,6 +151,36 @@ func (c *Client) InspectApp(ctx context.Context, feedID string, appID string) (*
 	return &response, nil
 }
 
+// ListAppVersions returns the published versions of an app by its name, the last tail of them if tail is above 0
+func (c *Client) ListAppVersions(ctx context.Context, feedID string, appName string, tail int) (KraudAppVersionList, error) {
+	u := url.URL{
+		Path: path.Join("/apis/kraudcloud.com/v1/feeds", feedID, "versions", appName),
+	}
+	if tail > 0 {
+		u.RawQuery = url.Values{
+			"tail": []string{strconv.Itoa(tail)},
+		}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		u.String(),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var response KraudAppVersionList
+	err = c.Do(req, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
 type LaunchParams struct {
 	Template  string
 	Env       map[string]string
@@ -157,7 +188,7 @@ type LaunchParams struct {
 	Detach    bool
 }
 
-func (c *Client) Launch(ctx context.Context, lp LaunchParams, out io.Writer) error {
+func (c *Client) launchRequest(ctx context.Context, lp LaunchParams) (*http.Request, error) {
 	u := &url.URL{
 		Path: "/apis/kraudcloud.com/v1/launch",
 		RawQuery: url.Values{
@@ -170,21 +201,21 @@ func (c *Client) Launch(ctx context.Context, lp LaunchParams, out io.Writer) err
 	mw := multipart.NewWriter(&buf)
 	err := mw.WriteField("docker-compose.yml", lp.Template)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	ew, err := mw.CreateFormField(".env")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = envparser.EncodeEnv(ew, lp.Env)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	err = mw.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req, err := http.NewRequestWithContext(
@@ -194,11 +225,20 @@ func (c *Client) Launch(ctx context.Context, lp LaunchParams, out io.Writer) err
 		&buf,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 
+	return req, nil
+}
+
+func (c *Client) Launch(ctx context.Context, lp LaunchParams, out io.Writer) error {
+	req, err := c.launchRequest(ctx, lp)
+	if err != nil {
+		return err
+	}
+
 	if lp.Detach {
 		req.Header.Set("Accept", "application/json")
 	}
@@ -216,6 +256,23 @@ func (c *Client) Launch(ctx context.Context, lp LaunchParams, out io.Writer) err
 	return err
 }
 
+// StartLaunch launches a compose template without waiting for it.
+// the output of the launch can be followed with LaunchAttach
+func (c *Client) StartLaunch(ctx context.Context, lp LaunchParams) (*KraudLaunchAppResponse, error) {
+	req, err := c.launchRequest(ctx, lp)
+	if err != nil {
+		return nil, err
+	}
+
+	var response KraudLaunchAppResponse
+	err = c.Do(req, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
 // errorWriterWrapper is a wrapper around a writer that checks for the string `Error:`
 // and returns an error with the line's content if found
 type errorWriterWrapper struct {
@@ -270,6 +327,13 @@ func (c *Client) LaunchApp(ctx context.Context, feedID string, appID string, par
 }
 
 func (c *Client) LaunchAttach(ctx context.Context, w io.Writer, launchID string) error {
+	_, err := c.LaunchAttachDeployments(ctx, w, launchID)
+	return err
+}
+
+// LaunchAttachDeployments copies the output of a launch to w like LaunchAttach,
+// and returns the AIDs of the deployments the launch reported
+func (c *Client) LaunchAttachDeployments(ctx context.Context, w io.Writer, launchID string) ([]string, error) {
 	u := &url.URL{
 		Scheme: c.baseURL.Scheme,
 		Host:   c.baseURL.Host,
@@ -280,26 +344,27 @@ func (c *Client) LaunchAttach(ctx context.Context, w io.Writer, launchID string)
 		HTTPClient: c.HTTPClient,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var aids []string
 	for {
 		t, msgReader, err := conn.Reader(ctx)
-		if errors.Is(err, io.EOF) {
-			return nil
+		if errors.Is(err, io.EOF) || websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+			return aids, nil
 		}
 
 		if err != nil {
-			return err
+			return aids, err
 		}
 
 		if t != websocket.MessageText {
 			continue
 		}
 
-		err = CopyWS(w, msgReader)
+		err = copyLaunchMessages(w, msgReader, &aids)
 		if err != nil {
-			return err
+			return aids, err
 		}
 	}
 }
@@ -314,6 +379,12 @@ func MustJSONString(v any) string {
 }
 
 func CopyWS(w io.Writer, r io.Reader) error {
+	return copyLaunchMessages(w, r, nil)
+}
+
+// copyLaunchMessages copies the logs of launch websocket messages to w
+// and collects the deployment AIDs of metadata messages into aids
+func copyLaunchMessages(w io.Writer, r io.Reader, aids *[]string) error {
 	decoder := json.NewDecoder(r)
 
 	for {
@@ -343,6 +414,10 @@ func CopyWS(w io.Writer, r io.Reader) error {
 			return err
 		}
 
+		if aids != nil {
+			*aids = append(*aids, meta.DeploymentAids...)
+		}
+
 		if meta.Error != nil {
 			// we don't actually want to return the error that was sent back,
 			// because it was already printed
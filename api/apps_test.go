@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"nhooyr.io/websocket"
+)
+
+func TestLaunchAttachDeployments(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apis/kraudcloud.com/v1/launch", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("namespace") != "default" {
+			t.Errorf("namespace = %q", r.URL.Query().Get("namespace"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"launch_id":"l-1"}`))
+	})
+	mux.HandleFunc("/apis/kraudcloud.com/v1/launch/l-1/attach", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, msg := range []string{
+			`{"log":"creating deployment web\n"}`,
+			`{"log":"creating deployment db\n"}`,
+			`{"deployment_aids":["d-web","d-db"]}`,
+		} {
+			conn.Write(r.Context(), websocket.MessageText, []byte(msg))
+		}
+		conn.Close(websocket.StatusNormalClosure, "")
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	c := NewClient("", u)
+	ctx := context.Background()
+
+	launch, err := c.StartLaunch(ctx, LaunchParams{Template: "services: {}", Namespace: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if launch.LaunchID != "l-1" {
+		t.Fatalf("launch id = %q", launch.LaunchID)
+	}
+
+	var out bytes.Buffer
+	aids, err := c.LaunchAttachDeployments(ctx, &out, launch.LaunchID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"d-web", "d-db"}; !reflect.DeepEqual(aids, want) {
+		t.Errorf("aids = %v, want %v", aids, want)
+	}
+	if want := "creating deployment web\ncreating deployment db\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestListAppVersions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/kraudcloud.com/v1/feeds/f-1/versions/web" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if r.URL.Query().Get("tail") != "2" {
+			t.Errorf("tail = %q", r.URL.Query().Get("tail"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"v-2","version":2,"changelog":"fix ports","created_at":"2023-05-01T10:00:00Z"},{"id":"v-3","version":3,"changelog":"","created_at":"2023-05-02T10:00:00Z"}]`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	versions, err := NewClient("", u).ListAppVersions(context.Background(), "f-1", "web", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 2 || versions[0].Version != 2 || versions[0].Changelog != "fix ports" || versions[1].ID != "v-3" {
+		t.Errorf("versions = %+v", versions)
+	}
+}
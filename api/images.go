@@ -5,6 +5,8 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+
+	"github.com/docker/docker/api/types"
 )
 
 func (c *Client) ListImages(ctx context.Context) (*KraudImageNameList, error) {
@@ -79,3 +81,9 @@ func (c *Client) CreateImage(ctx context.Context, body CreateImageJSONBody) (*Kr
 
 	return response, nil
 }
+
+// DeleteImage deletes an image by its name through the docker api
+func (c *Client) DeleteImage(ctx context.Context, name string) error {
+	_, err := c.DockerClient().ImageRemove(ctx, name, types.ImageRemoveOptions{})
+	return err
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -29,7 +30,7 @@ func (c *Client) ListLayers(ctx context.Context) (*KraudLayerList, error) {
 	return response, nil
 }
 
-func (c *Client) PushLayer(ctx context.Context, oid string, b io.Reader, size uint64) (*KraudLayer, error) {
+func (c *Client) PushLayer(ctx context.Context, oid string, b io.Reader, size uint64, encoding string) (*KraudLayer, error) {
 
 	req, err := http.NewRequestWithContext(
 		ctx,
@@ -38,13 +39,14 @@ func (c *Client) PushLayer(ctx context.Context, oid string, b io.Reader, size ui
 		b,
 	)
 
-	req.Header.Set("Content-Type", "application/x-tar")
-	req.ContentLength = int64(size)
-
 	if err != nil {
 		return nil, err
 	}
 
+	req.Header.Set("Content-Type", "application/x-tar")
+	req.Header.Set("Content-Encoding", encoding)
+	req.ContentLength = int64(size)
+
 	var response = &KraudLayer{}
 	err = c.Do(req, &response)
 	if err != nil {
@@ -53,3 +55,39 @@ func (c *Client) PushLayer(ctx context.Context, oid string, b io.Reader, size ui
 
 	return response, nil
 }
+
+// DownloadLayer returns the stored blob of a layer, as it was uploaded
+func (c *Client) DownloadLayer(ctx context.Context, id string) (io.ReadCloser, error) {
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		"/apis/kr.vmm/v1/layer/"+id,
+		nil,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.DoRaw(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode > 299 {
+		defer resp.Body.Close()
+
+		var ee ErrorResponse
+		err := json.NewDecoder(resp.Body).Decode(&ee)
+		if err != nil {
+			return nil, fmt.Errorf("%s", resp.Status)
+		}
+		if ee.Message != "" {
+			return nil, fmt.Errorf("%s", ee.Message)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
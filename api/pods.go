@@ -91,6 +91,48 @@ func (c *Client) EditPod(ctx context.Context, search string, pod *KraudPod) erro
 	return nil
 }
 
+func (c *Client) InspectDeployment(ctx context.Context, search string) (*KraudDeployment, error) {
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"GET",
+		"/apis/kraudcloud.com/v1/deployments/"+search,
+		nil,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var response = &KraudDeployment{}
+	err = c.Do(req, response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (c *Client) DeleteDeployment(ctx context.Context, namespace string, name string) error {
+	u, err := url.JoinPath("/apis/apps/v1/namespaces", namespace, "deployments", name)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		"DELETE",
+		u,
+		nil,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return c.Do(req, nil)
+}
+
 type SSHParams struct {
 	PodID   string
 	Env     []string
@@ -1,12 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
 	"github.com/kraudcloud/cli/api"
 	"github.com/kraudcloud/cli/completions"
+	"github.com/kraudcloud/cli/compose"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
 )
 
 func appsCMD() *cobra.Command {
@@ -16,9 +25,14 @@ func appsCMD() *cobra.Command {
 		Short:   "Manage apps",
 	}
 
+	c.AddCommand(appsInit())
+	c.AddCommand(appsLint())
+	c.AddCommand(appsRender())
 	c.AddCommand(appsPush())
 	c.AddCommand(appsLs())
 	c.AddCommand(appsInspect())
+	c.AddCommand(appsVersions())
+	c.AddCommand(appsDiff())
 	c.AddCommand(appsRun())
 
 	return c
@@ -53,6 +67,139 @@ func appsLs() *cobra.Command {
 	return c
 }
 
+func appsInit() *cobra.Command {
+	from := []string{}
+	app := compose.App{Name: composeProjectName()}
+	force := false
+
+	c := &cobra.Command{
+		Use:   "init [app.yaml]",
+		Short: "Create an app template from a docker-compose file",
+		Long: `Create an app template from a docker-compose file, ready to edit and push with kra apps push.
+
+Every variable the compose file references becomes a configuration variable of the app.
+Its kind, default and whether it is required are inferred from how the variable is used.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out := "app.yaml"
+			if len(args) > 0 {
+				out = args[0]
+			}
+
+			if _, err := os.Stat(out); err == nil && !force {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s exists already, use --force to overwrite it\n", out)
+				os.Exit(1)
+			}
+
+			_, template, err := loadComposeTemplate(from)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error reading docker-compose file: %v\n", err)
+				os.Exit(1)
+			}
+
+			if app.Label == "" {
+				app.Label = app.Name
+			}
+
+			data, err := compose.NewAppTemplate(app, template)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error creating app template: %v\n", err)
+				os.Exit(1)
+			}
+
+			err = os.WriteFile(out, data, 0644)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error writing %s: %v\n", out, err)
+				os.Exit(1)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "wrote %s, review the app metadata and config before pushing it\n", out)
+		},
+	}
+
+	c.Flags().StringSliceVar(&from, "from", from, composeFilesUsage)
+	c.Flags().StringVar(&app.Name, "name", app.Name, "name of the app (default the directory name)")
+	c.Flags().StringVar(&app.Label, "label", app.Label, "human readable name of the app (default the name)")
+	c.Flags().StringVar(&app.Description, "description", app.Description, "description of the app")
+	c.Flags().BoolVar(&force, "force", force, "overwrite an existing app template")
+
+	return c
+}
+
+func appsLint() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "lint <app.yaml>",
+		Short: "Check an app template for problems before pushing it",
+		Long: `Check an app template for problems before pushing it.
+
+The app metadata and config variables are checked, as well as the compose file
+against the compose specification and for features kraud doesn't support.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			template, err := os.ReadFile(args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error reading app template: %v\n", err)
+				os.Exit(1)
+			}
+
+			doc, issues := compose.LintApp(template)
+			if doc != nil {
+				issues = append(issues, lintKraud(cmd.Context(), doc, false)...)
+				compose.SortIssues(issues)
+			}
+
+			switch OUTPUT_FORMAT {
+			case "json":
+				if issues == nil {
+					issues = []compose.Issue{}
+				}
+				identJSONEncoder(cmd.OutOrStdout(), issues)
+			default:
+				printComposeIssues(cmd.OutOrStdout(), args[:1], issues)
+			}
+
+			if compose.HasErrors(issues) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	return c
+}
+
+func appsRender() *cobra.Command {
+	values := map[string]string{}
+
+	c := &cobra.Command{
+		Use:   "render <app.yaml>",
+		Short: "Render an app template with a config, like launching it would",
+		Long: `Render an app template with a config, like launching it would.
+
+Config variables that are not set with --set use their default.
+The resulting docker-compose file is printed without the app metadata.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			template, err := os.ReadFile(args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error reading app template: %v\n", err)
+				os.Exit(1)
+			}
+
+			rendered, err := compose.RenderApp(template, values)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error rendering app template: %v\n", err)
+				os.Exit(1)
+			}
+
+			cmd.OutOrStdout().Write(rendered)
+		},
+	}
+
+	c.Flags().StringToStringVar(&values, "set", values, "set config variables")
+
+	return c
+}
+
 func appsPush() *cobra.Command {
 	feed := ""
 
@@ -131,20 +278,213 @@ func appsInspect() *cobra.Command {
 	return c
 }
 
+func appsVersions() *cobra.Command {
+	feed := ""
+	tail := 0
+
+	c := &cobra.Command{
+		Use:   "versions <app>",
+		Short: "List the published versions of an app",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if feed == "" {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completions.AppOptions(API(), feed, cmd, args, toComplete)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			feedID := completions.FeedFromArg(cmd.Context(), API(), feed)
+			appName := completions.AppNameFromArg(cmd.Context(), API(), feedID, args[0])
+
+			versions, err := API().ListAppVersions(cmd.Context(), feedID, appName, tail)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing app versions: %v\n", err)
+				os.Exit(1)
+			}
+
+			sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+
+			switch OUTPUT_FORMAT {
+			case "json":
+				identJSONEncoder(cmd.OutOrStdout(), versions)
+			default:
+				table := NewTable("version", "created", "changelog")
+				for _, v := range versions {
+					table.AddRow(fmt.Sprintf("v%d", v.Version), humanize.Time(v.CreatedAt), v.Changelog)
+				}
+				table.Print()
+			}
+		},
+	}
+
+	appsFeedFlag(c, &feed)
+	c.Flags().IntVar(&tail, "tail", tail, "only list the last N versions (default all)")
+	return c
+}
+
+func appsDiff() *cobra.Command {
+	feed := ""
+
+	c := &cobra.Command{
+		Use:   "diff <app> <version> [version]",
+		Short: "Show how an app changed between two versions",
+		Long: `Show how an app changed between two versions, as a unified diff.
+
+Versions are given as v3 or 3. Without a second version, the latest version is compared.
+The kraud api returns the metadata and config of each version, but not its compose services,
+so only changes to the metadata and config are shown.`,
+		Args: cobra.RangeArgs(2, 3),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if feed == "" || len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completions.AppOptions(API(), feed, cmd, args, toComplete)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			feedID := completions.FeedFromArg(cmd.Context(), API(), feed)
+			appName := completions.AppNameFromArg(cmd.Context(), API(), feedID, args[0])
+
+			from, err := parseAppVersion(args[1])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+
+			to := 0
+			if len(args) > 2 {
+				to, err = parseAppVersion(args[2])
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			versions, err := API().ListAppVersions(cmd.Context(), feedID, appName, 0)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing app versions: %v\n", err)
+				os.Exit(1)
+			}
+
+			fromName, fromApp, err := appVersionMetadata(cmd.Context(), feedID, versions, from)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+
+			toName, toApp, err := appVersionMetadata(cmd.Context(), feedID, versions, to)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+
+			diff := compose.Diff(fromName, fromApp, toName, toApp)
+			for _, line := range strings.SplitAfter(diff, "\n") {
+				switch {
+				case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+					color.New(color.Bold).Fprint(cmd.OutOrStdout(), line)
+				case strings.HasPrefix(line, "+"):
+					color.New(color.FgGreen).Fprint(cmd.OutOrStdout(), line)
+				case strings.HasPrefix(line, "-"):
+					color.New(color.FgRed).Fprint(cmd.OutOrStdout(), line)
+				case strings.HasPrefix(line, "@@"):
+					color.New(color.FgCyan).Fprint(cmd.OutOrStdout(), line)
+				default:
+					fmt.Fprint(cmd.OutOrStdout(), line)
+				}
+			}
+		},
+	}
+
+	appsFeedFlag(c, &feed)
+	return c
+}
+
+// parseAppVersion parses an app version given as v3 or 3
+func parseAppVersion(s string) (int, error) {
+	v, err := strconv.Atoi(strings.TrimPrefix(s, "v"))
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("invalid version %q, expected a version like v3", s)
+	}
+	return v, nil
+}
+
+// appVersionMetadata returns the name of a published version of an app, the latest one if version is 0,
+// and its metadata as it is written in an app template
+func appVersionMetadata(ctx context.Context, feedID string, versions api.KraudAppVersionList, version int) (string, []byte, error) {
+	var found *api.KraudAppVersion
+	for i, v := range versions {
+		if (version == 0 && (found == nil || v.Version > found.Version)) || v.Version == version {
+			found = &versions[i]
+		}
+	}
+
+	if found == nil {
+		if version == 0 {
+			return "", nil, fmt.Errorf("the app has no versions")
+		}
+		return "", nil, fmt.Errorf("no such version: v%d", version)
+	}
+
+	overview, err := API().InspectApp(ctx, feedID, found.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("error getting v%d: %w", found.Version, err)
+	}
+
+	app := compose.App{
+		Name:        overview.Name,
+		Label:       overview.Label,
+		Description: overview.Description,
+		Config:      overview.Config,
+	}
+	if overview.IconURL != nil {
+		app.IconURL = *overview.IconURL
+	}
+	if overview.PostLaunch != nil {
+		app.PostLaunch = *overview.PostLaunch
+	}
+
+	metadata, err := compose.MarshalApp(app)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("v%d", found.Version), metadata, nil
+}
+
+// appsFeedFlag adds the required --feed flag of app commands
+func appsFeedFlag(c *cobra.Command, feed *string) {
+	c.Flags().StringVarP(feed, "feed", "f", "", "app store")
+	c.MarkFlagRequired("feed")
+	c.RegisterFlagCompletionFunc("feed", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completions.FeedOptions(API(), cmd, args, toComplete)
+	})
+}
+
 type errResp struct {
 	Message string `json:"message"`
 	Error   string `json:"error"`
 }
 
-// TODO: maybe support .env files?
 func appsRun() *cobra.Command {
 	feed := ""
 	env := map[string]string{}
+	envFile := ""
+	valuesFile := ""
 	namespace := "default"
+	roles := []string{}
+	noPrompt := false
 
 	c := &cobra.Command{
-		Use:    "run <app>",
-		Hidden: true, // hide launch apps for now. needs polish
+		Use:   "run <app>",
+		Short: "Launch an app",
+		Long: `Launch an app, asking for the values of its config variables.
+
+Values are taken from --values, then --env-file, then --env, later ones taking precedence.
+Config variables without a value are asked for on the terminal, unless --no-prompt is given
+or there is no terminal, in which case their default is used.`,
+		Args: cobra.ExactArgs(1),
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			// feed must exist to complete apps
 			if feed == "" {
@@ -153,45 +493,108 @@ func appsRun() *cobra.Command {
 
 			return completions.AppOptions(API(), feed, cmd, args, toComplete)
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
+		Run: func(cmd *cobra.Command, args []string) {
 			feedID := completions.FeedFromArg(cmd.Context(), API(), feed)
 			appID := completions.AppFromArg(cmd.Context(), API(), feedID, args[0])
 
+			values, err := loadAppValues(valuesFile, envFile)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+			maps.Copy(values, env)
+
+			overview, err := API().InspectApp(cmd.Context(), feedID, appID)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting app: %v\n", err)
+				os.Exit(1)
+			}
+
+			// apps without declared config get the values as they are
+			if overview.Config != nil {
+				if !noPrompt && isatty.IsTerminal(os.Stdin.Fd()) {
+					err = promptAppValues(overview.Config.AdditionalProperties, values)
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "error reading config: %v\n", err)
+						os.Exit(1)
+					}
+				}
+
+				app := compose.App{Name: overview.Name, Config: overview.Config}
+				values, err = app.Env(values)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+					os.Exit(1)
+				}
+			}
+
 			body := api.KraudLaunchSettings{
 				Config: api.KraudLaunchSettings_Config{
-					AdditionalProperties: env,
+					AdditionalProperties: values,
 				},
 				ProjectName: namespace,
+				Roles:       roles,
 			}
 
 			resp, err := API().LaunchApp(cmd.Context(), feedID, appID, body)
 			if err != nil {
 				fmt.Fprintf(cmd.ErrOrStderr(), "error launching app: %v\n", err)
-				return nil
+				os.Exit(1)
 			}
 
 			if ok, _ := cmd.Flags().GetBool("detach"); ok {
-				return nil
+				return
 			}
 
 			err = API().LaunchAttach(cmd.Context(), os.Stdout, resp.LaunchID)
 			if err != nil {
 				fmt.Fprintf(cmd.ErrOrStderr(), "error attaching to app: %v\n", err)
-				return nil
+				os.Exit(1)
 			}
-
-			return nil
 		},
 	}
 
-	c.Flags().StringVarP(&feed, "feed", "f", "", "app store")
-	c.MarkFlagRequired("feed")
-	c.RegisterFlagCompletionFunc("feed", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-		return completions.FeedOptions(API(), cmd, args, toComplete)
-	})
+	appsFeedFlag(c, &feed)
 
 	c.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace to run the app in")
-	c.Flags().StringToStringVarP(&env, "env", "e", env, "environment to pass to the app")
+	c.Flags().StringToStringVarP(&env, "env", "e", env, "set config variables")
+	c.Flags().StringVar(&envFile, "env-file", envFile, "set config variables from a .env file")
+	c.Flags().StringVar(&valuesFile, "values", valuesFile, "set config variables from a yaml file of KEY: value pairs")
+	c.Flags().StringSliceVar(&roles, "role", roles, "UUIDs of the roles to apply to the namespace (default the users and admin roles)")
+	c.Flags().BoolVar(&noPrompt, "no-prompt", noPrompt, "don't ask for config variables without a value, use their default")
 	c.Flags().BoolP("detach", "d", false, "detach from the app launch process")
 	return c
 }
+
+// promptAppValues asks for the config variables that have no value yet, in order of their name
+func promptAppValues(config map[string]api.KraudAppTemplateConfig, values map[string]string) error {
+	var missing []string
+	for _, name := range sortedKeys(config) {
+		if values[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	p, err := newAppConfigPrompter()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	for _, name := range missing {
+		value, err := p.Prompt(name, config[name])
+		if err != nil {
+			return err
+		}
+
+		if value != "" {
+			values[name] = value
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kraudcloud/cli/api"
+	"github.com/kraudcloud/cli/compose"
+	"github.com/kraudcloud/cli/compose/envparser"
+	"github.com/mattn/go-tty"
+	"github.com/mitchellh/colorstring"
+	"gopkg.in/yaml.v3"
+)
+
+// loadAppValues loads app config values from a yaml file of the form KEY: value, then an env file.
+// later files override earlier ones
+func loadAppValues(valuesFile string, envFile string) (map[string]string, error) {
+	values := map[string]string{}
+
+	if valuesFile != "" {
+		data, err := os.ReadFile(valuesFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading values file: %w", err)
+		}
+
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("error reading values file %s: %w", valuesFile, err)
+		}
+	}
+
+	if envFile != "" {
+		f, err := os.Open(envFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading env file: %w", err)
+		}
+		defer f.Close()
+
+		env, err := envparser.EnvMapFromReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("error reading env file %s: %w", envFile, err)
+		}
+
+		for k, v := range env {
+			values[k] = v
+		}
+	}
+
+	return values, nil
+}
+
+// appConfigPrompter asks for the values of app config variables on the terminal
+type appConfigPrompter struct {
+	tty *tty.TTY
+	w   io.Writer
+}
+
+func newAppConfigPrompter() (*appConfigPrompter, error) {
+	t, err := tty.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &appConfigPrompter{tty: t, w: t.Output()}, nil
+}
+
+func (p *appConfigPrompter) Close() error {
+	return p.tty.Close()
+}
+
+// Prompt asks for the value of a config variable until it is valid for its kind.
+// an empty answer takes the default, options can be chosen by their number
+func (p *appConfigPrompter) Prompt(name string, c api.KraudAppTemplateConfig) (string, error) {
+	label := c.Label
+	if label == "" {
+		label = name
+	}
+
+	colorstring.Fprintf(p.w, "\n[bold]%s[reset] (%s)", label, name)
+	if c.Required {
+		colorstring.Fprintf(p.w, " [red]required")
+	}
+	fmt.Fprintln(p.w)
+
+	if c.Description != "" {
+		fmt.Fprintf(p.w, "  %s\n", c.Description)
+	}
+
+	for i, o := range c.Options {
+		fmt.Fprintf(p.w, "  %d) %s", i+1, o.Label)
+		if o.Description != "" {
+			fmt.Fprintf(p.w, " - %s", o.Description)
+		}
+		fmt.Fprintln(p.w)
+	}
+
+	for {
+		fmt.Fprint(p.w, "> ")
+		if c.Default != "" && c.Kind != compose.AppConfigPassword {
+			colorstring.Fprintf(p.w, "[dark_gray](%s) ", c.Default)
+		}
+
+		var answer string
+		var err error
+		if c.Kind == compose.AppConfigPassword {
+			answer, err = p.tty.ReadPasswordNoEcho()
+		} else {
+			answer, err = p.tty.ReadString()
+		}
+		if err != nil {
+			return "", err
+		}
+
+		value := strings.TrimSpace(answer)
+		if i, err := strconv.Atoi(value); err == nil && c.Kind == compose.AppConfigSelect && i >= 1 && i <= len(c.Options) {
+			value = c.Options[i-1].Value
+		}
+
+		if value == "" {
+			value = c.Default
+		}
+
+		if value == "" {
+			if !c.Required {
+				return "", nil
+			}
+
+			colorstring.Fprintf(p.w, "[red]a value is required\n")
+			continue
+		}
+
+		if err := compose.CheckAppConfig(c, value); err != nil {
+			colorstring.Fprintf(p.w, "[red]%v\n", err)
+			continue
+		}
+
+		return value, nil
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/k0kubun/go-ansi"
+	"github.com/kraudcloud/cli/api"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/colorstring"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/spf13/cobra"
+)
+
+func buildCMD() *cobra.Command {
+	tag := ""
+	dockerfile := "Dockerfile"
+	target := ""
+	noCache := false
+	pull := false
+	buildArgs := map[string]string{}
+
+	c := &cobra.Command{
+		Use:   "build -t <image> [PATH]",
+		Short: "Build an image on the kraud",
+		Long: `Build an image on the kraud.
+
+The build context is uploaded to the kraud and built remotely,
+the resulting image is available to "kra up" right away.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			contextDir := "."
+			if len(args) > 0 {
+				contextDir = args[0]
+			}
+
+			buildContext, err := buildContextArchive(contextDir, dockerfile)
+			if buildContext != nil {
+				defer os.Remove(buildContext.Name())
+				defer buildContext.Close()
+			}
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error creating build context: %v\n", err)
+				return nil
+			}
+
+			stat, err := buildContext.Stat()
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error creating build context: %v\n", err)
+				return nil
+			}
+
+			var reader io.Reader = buildContext
+			if isatty.IsTerminal(os.Stdout.Fd()) {
+				bar := NewBar(int(stat.Size()), "[cyan]"+tag+"[reset] Uploading build context ")
+				defer bar.Finish()
+
+				reader = io.TeeReader(reader, bar)
+			} else {
+				colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+tag+"[reset] Uploading build context")
+			}
+
+			opts := types.ImageBuildOptions{
+				Tags:       []string{tag},
+				Dockerfile: filepath.ToSlash(dockerfile),
+				Target:     target,
+				NoCache:    noCache,
+				PullParent: pull,
+				Remove:     true,
+				BuildArgs:  map[string]*string{},
+			}
+
+			for k, v := range buildArgs {
+				v := v
+				opts.BuildArgs[k] = &v
+			}
+
+			rsp, err := API().DockerClient().ImageBuild(ctx, reader, opts)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error building image: %v\n", err)
+				os.Exit(1)
+			}
+			defer rsp.Body.Close()
+
+			imageID := ""
+			err = jsonmessage.DisplayJSONMessagesStream(rsp.Body, cmd.ErrOrStderr(), os.Stderr.Fd(), isatty.IsTerminal(os.Stderr.Fd()), func(msg jsonmessage.JSONMessage) {
+				var result types.BuildResult
+				if msg.Aux != nil && json.Unmarshal(*msg.Aux, &result) == nil && result.ID != "" {
+					imageID = result.ID
+				}
+			})
+			if err != nil {
+				colorstring.Fprintf(cmd.ErrOrStderr(), "[red]%v\n", err)
+				os.Exit(1)
+			}
+
+			img, err := buildRegisterImage(ctx, tag, imageID)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error registering image: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), img.AID)
+			return nil
+		},
+	}
+
+	c.Flags().StringVarP(&tag, "tag", "t", tag, "name of the built image")
+	c.Flags().StringVarP(&dockerfile, "file", "f", dockerfile, "path of the Dockerfile, relative to the build context")
+	c.Flags().StringVar(&target, "target", target, "build stage to build")
+	c.Flags().BoolVar(&noCache, "no-cache", noCache, "do not use the build cache")
+	c.Flags().BoolVar(&pull, "pull", pull, "always pull newer versions of base images")
+	c.Flags().StringToStringVar(&buildArgs, "build-arg", buildArgs, "set build-time variables")
+	c.MarkFlagRequired("tag")
+
+	return c
+}
+
+// buildRegisterImage makes sure the built image is available under its ref.
+// if the builder only produced an image id, the ref is added to it.
+// without an image id the build produced nothing, and whatever is stored under ref is stale
+func buildRegisterImage(ctx context.Context, ref string, imageID string) (*api.KraudImageName, error) {
+	if imageID == "" {
+		return nil, fmt.Errorf("the builder did not report an image id for %s", ref)
+	}
+
+	img, err := API().InspectImage(ctx, ref)
+	if err == nil && img.Amd64 != nil && img.Amd64.OciID == imageID {
+		return img, nil
+	}
+
+	img, err = API().InspectImage(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if img.Amd64 == nil {
+		return nil, fmt.Errorf("built image %s has no amd64 variant", imageID)
+	}
+
+	rsp, err := imageAddRef(ctx, img, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return rsp.Created, nil
+}
+
+// buildContextArchive writes the build context in dir to a gzipped tar temp file,
+// skipping files matched by .dockerignore
+func buildContextArchive(dir string, dockerfile string) (*os.File, error) {
+	var excludes []string
+
+	ignore, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		excludes, err = ignorefile.ReadAll(ignore)
+		ignore.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading .dockerignore: %w", err)
+		}
+	}
+
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .dockerignore: %w", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, dockerfile)); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp("", "krabuild")
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		// the builder always needs these, even if they are ignored
+		keep := rel == filepath.Clean(dockerfile) || rel == ".dockerignore"
+
+		if !keep {
+			skip, err := pm.MatchesOrParentMatches(filepath.ToSlash(rel))
+			if err != nil {
+				return err
+			}
+
+			if skip {
+				// exclusions may re-include files below an ignored directory
+				if d.IsDir() && !pm.Exclusions() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+
+	return file, err
+}
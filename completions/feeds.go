@@ -65,3 +65,18 @@ func AppFromArg(ctx context.Context, client *api.Client, feedID, arg string) str
 
 	return arg
 }
+
+func AppNameFromArg(ctx context.Context, client *api.Client, feedID, arg string) string {
+	apps, err := client.ListApps(ctx, feedID)
+	if err != nil {
+		return arg
+	}
+
+	for _, i := range apps.Items {
+		if i.ID == arg {
+			return i.Name
+		}
+	}
+
+	return arg
+}
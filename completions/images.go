@@ -0,0 +1,24 @@
+package completions
+
+import (
+	"github.com/kraudcloud/cli/api"
+	"github.com/spf13/cobra"
+)
+
+func ImageOptions(client *api.Client, cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	images, err := client.ListImages(cmd.Context())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	var out []string
+	for _, i := range images.Items {
+		out = append(out, i.Ref)
+	}
+
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kraudcloud/cli/compose"
+	"github.com/kraudcloud/cli/compose/envparser"
+	"github.com/mitchellh/colorstring"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+func composeCMD() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "compose",
+		Short: "Work with docker-compose files",
+	}
+
+	c.AddCommand(composeLintCMD())
+	c.AddCommand(composeConfigCMD())
+	c.AddCommand(composePsCMD())
+	c.AddCommand(composeLogsCMD())
+
+	return c
+}
+
+func composeLintCMD() *cobra.Command {
+	files := []string{}
+	env := map[string]string{}
+	envFile := ".env"
+	envSources := []string{}
+	offline := false
+
+	c := &cobra.Command{
+		Use:   "lint",
+		Short: "Check a docker-compose file for problems before launching it",
+		Long: `Check a docker-compose file for problems before launching it.
+
+The file is checked against the compose specification and for features kraud doesn't support.
+Referenced images are looked up on the kraud unless --offline is given.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			files, template, err := loadComposeTemplate(files)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error reading docker-compose file: %v\n", err)
+				os.Exit(1)
+			}
+
+			_, loaded, err := loadComposeEnv(cmd.ErrOrStderr(), template, env, envFile, envSources, false)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+			env := loaded.Values()
+
+			issues := lintCompose(cmd.Context(), files, env, !offline)
+
+			switch OUTPUT_FORMAT {
+			case "json":
+				if issues == nil {
+					issues = []compose.Issue{}
+				}
+				identJSONEncoder(cmd.OutOrStdout(), issues)
+			default:
+				printComposeIssues(cmd.OutOrStdout(), files, issues)
+			}
+
+			if compose.HasErrors(issues) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	c.Flags().StringSliceVarP(&files, "file", "f", files, composeFilesUsage)
+	c.Flags().StringToStringVarP(&env, "env", "e", env, "set environment variables")
+	c.Flags().StringVar(&envFile, "env-file", envFile, "set environment variables from a file")
+	c.Flags().StringArrayVar(&envSources, "env-source", envSources, envSourceUsage)
+	c.Flags().BoolVar(&offline, "offline", offline, "don't look up images on the kraud")
+
+	return c
+}
+
+func composeConfigCMD() *cobra.Command {
+	files := []string{}
+	env := map[string]string{}
+	envFile := ".env"
+	envSources := []string{}
+	resolveImageIDs := false
+	listServices := false
+	listVolumes := false
+	showSecrets := false
+	secretVars := []string{}
+
+	c := &cobra.Command{
+		Use:   "config",
+		Short: "Render the docker-compose file with all variables interpolated",
+		Long: `Render the docker-compose file with all variables interpolated.
+
+Values of secret variables are masked. Variables are secret if their name looks like
+a password, token or key, if they are listed with --secret, or if they are loaded
+from an --env-source.
+
+--resolve-image-ids pins images to repo@<id>, where the id is the config digest of the image
+on the kraud. It is not a registry manifest digest, so the pinned file is only meant for the kraud.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			_, template, err := loadComposeTemplate(files)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error reading docker-compose file: %v\n", err)
+				os.Exit(1)
+			}
+
+			_, loaded, err := loadComposeEnv(cmd.ErrOrStderr(), template, env, envFile, envSources, false)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+			env := loaded.Values()
+
+			interpolated, err := envparser.Interpolate(template, env)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error interpolating docker-compose file: %v\n", err)
+				os.Exit(1)
+			}
+
+			spec, err := compose.Parse(bytes.NewReader(interpolated))
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error parsing docker-compose file: %v\n", err)
+				os.Exit(1)
+			}
+
+			if listServices || listVolumes {
+				var names []string
+				if listServices {
+					names = append(names, sortedKeys(spec.Services)...)
+				}
+				if listVolumes {
+					names = append(names, sortedKeys(spec.Volumes)...)
+				}
+
+				for _, name := range names {
+					fmt.Fprintln(cmd.OutOrStdout(), name)
+				}
+				return
+			}
+
+			if !showSecrets {
+				interpolated, err = envparser.Interpolate(template, maskSecretEnv(loaded, secretVars))
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error interpolating docker-compose file: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			var doc yaml.Node
+			err = yaml.Unmarshal(interpolated, &doc)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error parsing docker-compose file: %v\n", err)
+				os.Exit(1)
+			}
+
+			if resolveImageIDs {
+				pinned := &compose.Document{Root: &doc}
+				failed := false
+				for _, name := range sortedKeys(spec.Services) {
+					ref := spec.Services[name].Image
+					if ref == "" {
+						continue
+					}
+
+					img, err := API().InspectImage(cmd.Context(), ref)
+					if err == nil && img.Amd64 == nil {
+						err = fmt.Errorf("no amd64 variant")
+					}
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "error resolving image %s of service %s: %v\n", ref, name, err)
+						failed = true
+						continue
+					}
+
+					// the kraud only knows the config digest of images, not their manifest digest
+					err = pinned.SetServiceImage(name, imageRepository(ref)+"@"+img.Amd64.OciID)
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "error pinning image %s of service %s: %v\n", ref, name, err)
+						failed = true
+					}
+				}
+
+				if failed {
+					os.Exit(1)
+				}
+			}
+
+			enc := yaml.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent(2)
+			enc.Encode(&doc)
+			enc.Close()
+		},
+	}
+
+	c.Flags().StringSliceVarP(&files, "file", "f", files, composeFilesUsage)
+	c.Flags().StringToStringVarP(&env, "env", "e", env, "set environment variables")
+	c.Flags().StringVar(&envFile, "env-file", envFile, "set environment variables from a file")
+	c.Flags().StringArrayVar(&envSources, "env-source", envSources, envSourceUsage)
+	c.Flags().BoolVar(&resolveImageIDs, "resolve-image-ids", resolveImageIDs, "pin images to the id they currently resolve to on the kraud")
+	c.Flags().BoolVar(&listServices, "services", listServices, "only print the service names")
+	c.Flags().BoolVar(&listVolumes, "volumes", listVolumes, "only print the volume names")
+	c.Flags().BoolVar(&showSecrets, "show-secrets", showSecrets, "don't mask the values of secret variables")
+	c.Flags().StringSliceVar(&secretVars, "secret", secretVars, "treat these variables as secret")
+
+	return c
+}
+
+// maskSecretEnv returns the values of env with the values of secret variables masked.
+// variables are secret by name, if listed in secretVars, or if loaded from a secret store
+func maskSecretEnv(env envparser.Env, secretVars []string) map[string]string {
+	masked := make(map[string]string, len(env))
+	for k, l := range env {
+		v := l.Value
+		if v != "" && (compose.IsSecretVar(k) || slices.Contains(secretVars, k) || isSecretEnvSource(l.Source)) {
+			v = "<masked>"
+		}
+		masked[k] = v
+	}
+	return masked
+}
+
+// isSecretEnvSource reports whether a loaded variable came from an --env-source secret store
+func isSecretEnvSource(source string) bool {
+	kind, _, _ := strings.Cut(source, ":")
+	return slices.Contains([]string{"keyring", "pass", "sops", "exec"}, kind)
+}
+
+// composeUnsupported are service keys kraud ignores or rejects
+var composeUnsupported = map[string]string{
+	"cgroup_parent": "cgroups are managed by kraud",
+	"devices":       "host devices are not available",
+	"ipc":           "ipc namespaces can't be shared",
+	"isolation":     "isolation is managed by kraud",
+	"links":         "links are not supported, services reach each other by name",
+	"network_mode":  "network modes are not supported, use networks",
+	"pid":           "pid namespaces can't be shared",
+	"privileged":    "privileged containers are not supported",
+	"runtime":       "the container runtime is managed by kraud",
+	"security_opt":  "security options are not supported",
+	"sysctls":       "sysctls are not supported",
+	"userns_mode":   "user namespaces are managed by kraud",
+	"uts":           "uts namespaces can't be shared",
+	"volumes_from":  "volumes_from is not supported, use named volumes",
+}
+
+// composeValueSet reports whether the value of a key turns its feature on.
+// null, false and empty values don't, nor do variables that could not be interpolated
+func composeValueSet(n *yaml.Node) bool {
+	for n != nil && n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	if n == nil {
+		return false
+	}
+
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if n.Value == "" || strings.Contains(n.Value, "$") {
+			return false
+		}
+
+		switch n.ShortTag() {
+		case "!!null":
+			return false
+		case "!!bool":
+			b, _ := strconv.ParseBool(n.Value)
+			return b
+		}
+
+		return true
+
+	case yaml.SequenceNode, yaml.MappingNode:
+		return len(n.Content) > 0
+	}
+
+	return true
+}
+
+// lintCompose checks compose files locally, including for features kraud doesn't support.
+// the files are merged, and issues point at the file a value comes from
+func lintCompose(ctx context.Context, files []string, env map[string]string, checkImages bool) []compose.Issue {
+	docs, err := readComposeFiles(files)
+	if err != nil {
+		return []compose.Issue{{Severity: compose.SeverityError, Message: err.Error()}}
+	}
+
+	doc, issues := compose.LintFiles(files, docs, env)
+	if doc == nil {
+		return issues
+	}
+
+	issues = append(issues, lintKraud(ctx, doc, checkImages)...)
+	compose.SortIssues(issues)
+	return issues
+}
+
+// lintKraud checks a linted compose document for features kraud doesn't support, and optionally that its images exist
+func lintKraud(ctx context.Context, doc *compose.Document, checkImages bool) []compose.Issue {
+	var issues []compose.Issue
+
+	for _, name := range sortedKeys(doc.File.Services) {
+		s := doc.File.Services[name]
+
+		for _, key := range sortedKeys(composeUnsupported) {
+			if composeValueSet(doc.Node("services", name, key)) {
+				issues = append(issues, doc.Issue(compose.SeverityWarning, composeUnsupported[key], "services", name, key))
+			}
+		}
+
+		if s.Build != nil && s.Image != "" {
+			issues = append(issues, doc.Issue(compose.SeverityWarning, "build is ignored unless kra up --build is used, the image is expected to be pushed already", "services", name, "build"))
+		}
+
+		if !checkImages || s.Image == "" {
+			continue
+		}
+
+		// the document is interpolated already
+		ref := s.Image
+		if strings.Contains(ref, "$") {
+			continue
+		}
+
+		if _, err := API().InspectImage(ctx, ref); err != nil {
+			issues = append(issues, doc.Issue(compose.SeverityError, fmt.Sprintf("image %s not found: %v", ref, err), "services", name, "image"))
+		}
+	}
+
+	for _, name := range sortedKeys(doc.File.Volumes) {
+		if doc.File.Volumes[name].Driver != "" {
+			issues = append(issues, doc.Issue(compose.SeverityWarning, "volume drivers are not supported", "volumes", name, "driver"))
+		}
+	}
+
+	for _, name := range sortedKeys(doc.File.Networks) {
+		if doc.File.Networks[name].Driver != "" {
+			issues = append(issues, doc.Issue(compose.SeverityWarning, "network drivers are not supported", "networks", name, "driver"))
+		}
+	}
+
+	return issues
+}
+
+func printComposeIssues(w io.Writer, files []string, issues []compose.Issue) {
+	for _, issue := range issues {
+		file := issue.File
+		if file == "" {
+			file = strings.Join(files, ",")
+		}
+
+		color := "[yellow]"
+		if issue.Severity == compose.SeverityError {
+			color = "[red]"
+		}
+
+		colorstring.Fprintf(w, "%s:"+color+"%s\n", file, issue.String())
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
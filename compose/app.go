@@ -0,0 +1,509 @@
+package compose
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kraudcloud/cli/api"
+	"github.com/kraudcloud/cli/compose/envparser"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// App is the metadata of an app template, shown in the feed it is pushed to.
+// its fields are those of the app overview of a feed, and sit at the top level of the template next to the compose file
+type App struct {
+	Name        string                       `json:"name"`
+	Label       string                       `json:"label"`
+	Description string                       `json:"description"`
+	IconURL     string                       `json:"icon_url,omitempty"`
+	PostLaunch  string                       `json:"post_launch,omitempty"`
+	Config      *api.KraudAppOverview_Config `json:"config,omitempty"`
+}
+
+// appKeys are the top level keys of an app template that hold the app metadata.
+// the name of the app is also the name of the compose project, so it is not one of them
+var appKeys = []string{"label", "description", "icon_url", "post_launch", "config"}
+
+// kinds of app config variables that are inferred, checked and prompted for.
+// the api accepts other kinds too, their values are taken as they are
+const (
+	AppConfigText     = "text"
+	AppConfigPassword = "password"
+	AppConfigNumber   = "number"
+	AppConfigBool     = "bool"
+	AppConfigSelect   = "select"
+)
+
+var secretVarPattern = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|CREDENTIAL|PRIVATE|API_?KEY|_KEY$)`)
+
+// IsSecretVar reports whether the name of a variable looks like it holds a password, token or key
+func IsSecretVar(name string) bool {
+	return secretVarPattern.MatchString(name)
+}
+
+// AppConfigFromVars infers the app configuration from the variables referenced in a template:
+//
+//   - variables named like secrets are passwords, variables defaulting to a number or boolean are numbers or booleans
+//   - the default is the default of the variable, unless it refers to other variables
+//   - variables are required if they use ${VAR:?} or have neither a default nor an alternate value
+func AppConfigFromVars(vars map[string]envparser.Variable) map[string]api.KraudAppTemplateConfig {
+	config := make(map[string]api.KraudAppTemplateConfig, len(vars))
+	for name, v := range vars {
+		c := api.KraudAppTemplateConfig{
+			Label:   appConfigLabel(name),
+			Kind:    AppConfigText,
+			Default: v.Default,
+		}
+
+		// the default is written into the template, where it would be interpolated again
+		if strings.Contains(c.Default, "$") {
+			c.Default = ""
+		}
+
+		switch {
+		case IsSecretVar(name):
+			c.Kind = AppConfigPassword
+		case v.Default == "true" || v.Default == "false":
+			c.Kind = AppConfigBool
+		case v.Default != "":
+			if _, err := strconv.ParseFloat(v.Default, 64); err == nil {
+				c.Kind = AppConfigNumber
+			}
+		}
+
+		switch {
+		case v.Error != "":
+			c.Required = true
+			if v.Error != fmt.Sprintf("required variable %s is missing a value", name) {
+				c.Description = v.Error
+			}
+		case v.Default == "" && v.Alternate == "" && !v.UnsetEmpty:
+			c.Required = true
+		}
+
+		config[name] = c
+	}
+
+	return config
+}
+
+// appConfigLabel turns a variable name like DB_PASSWORD into a label like Db password
+func appConfigLabel(name string) string {
+	label := strings.ToLower(strings.ReplaceAll(name, "_", " "))
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return name
+	}
+
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// NewAppTemplate turns a compose template into an app template, adding the app metadata
+// with a configuration variable for every variable the template references.
+// the compose template is kept as it is, including its comments
+func NewAppTemplate(app App, template []byte) ([]byte, error) {
+	vars, err := envparser.ParseTemplateVars(bytes.NewReader(template))
+	if err != nil {
+		return nil, err
+	}
+
+	if app.Config == nil {
+		app.Config = &api.KraudAppOverview_Config{AdditionalProperties: AppConfigFromVars(vars)}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(template, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("a compose file must be a mapping")
+	}
+	root := doc.Content[0]
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		switch key := root.Content[i].Value; {
+		case slices.Contains(appKeys, key):
+			return nil, fmt.Errorf("the template is an app template already, it has %s", key)
+		case key == "name" && app.Name == "":
+			app.Name = root.Content[i+1].Value
+		}
+	}
+	root.Content = removeKey(root.Content, "name")
+
+	metadata, err := appNode(app)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep a comment at the top of the file at the top
+	if len(root.Content) > 0 {
+		metadata.Content[0].HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append(metadata.Content, root.Content...)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// appNode returns the app metadata as the yaml mapping it is written as in an app template
+func appNode(app App) (*yaml.Node, error) {
+	var data bytes.Buffer
+	if err := json.NewEncoder(&data).Encode(app); err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data.Bytes(), &doc); err != nil {
+		return nil, err
+	}
+	metadata := doc.Content[0]
+	blockStyle(metadata)
+
+	return metadata, nil
+}
+
+// MarshalApp returns the app metadata as it is written at the top of an app template
+func MarshalApp(app App) ([]byte, error) {
+	metadata, err := appNode(app)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(metadata); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// blockStyle resets the style of n and the nodes below it, so that json decoded as yaml is written as block yaml
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// decodeJSON decodes n into v through json, so that api types decode like they do from the api
+func decodeJSON(n *yaml.Node, v any) error {
+	var raw any
+	if err := n.Decode(&raw); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// CheckAppConfig reports whether value is valid for the kind of the config variable.
+// values of kinds that are not known here are valid
+func CheckAppConfig(c api.KraudAppTemplateConfig, value string) error {
+	switch c.Kind {
+	case AppConfigNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+	case AppConfigBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+	case AppConfigSelect:
+		if !slices.ContainsFunc(c.Options, func(o api.KraudAppTemplateConfigOption) bool { return o.Value == value }) {
+			return fmt.Errorf("%q is not one of the options", value)
+		}
+	}
+
+	return nil
+}
+
+// appMetadata returns the app metadata of the top level mapping of an app template,
+// or nil if it is a plain compose file
+func appMetadata(top *yaml.Node) *yaml.Node {
+	metadata := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	isApp := false
+
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		key := top.Content[i].Value
+		if key == "name" || slices.Contains(appKeys, key) {
+			metadata.Content = append(metadata.Content, top.Content[i], top.Content[i+1])
+		}
+		if slices.Contains(appKeys, key) {
+			isApp = true
+		}
+	}
+
+	if !isApp {
+		return nil
+	}
+
+	return metadata
+}
+
+// ParseApp returns the app metadata of an app template
+func ParseApp(template []byte) (*App, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(template, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 || resolve(doc.Content[0]).Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("a compose file must be a mapping")
+	}
+
+	metadata := appMetadata(resolve(doc.Content[0]))
+	if metadata == nil {
+		return nil, fmt.Errorf("not an app template, it has none of %s", strings.Join(appKeys, ", "))
+	}
+
+	var app App
+	if err := decodeJSON(metadata, &app); err != nil {
+		return nil, fmt.Errorf("invalid app metadata: %w", err)
+	}
+
+	return &app, nil
+}
+
+// LintApp checks an app template: the app metadata, the kinds and defaults of the config variables,
+// that the config matches the variables the template references, and the compose file like Lint.
+// the returned document is the compose file without the app metadata, nil if it is not an app template
+func LintApp(template []byte) (*Document, []Issue) {
+	var root yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(template)).Decode(&root); err != nil {
+		return nil, errorIssues(err)
+	}
+
+	doc := &Document{Root: &root, File: &File{}}
+	top := doc.Node()
+	if top == nil || top.Kind != yaml.MappingNode {
+		return nil, []Issue{doc.Issue(SeverityError, "a compose file must be a mapping")}
+	}
+
+	metadata := appMetadata(top)
+	if metadata == nil {
+		return nil, []Issue{{Line: 1, Severity: SeverityError, Message: fmt.Sprintf("not an app template, it has none of %s", strings.Join(appKeys, ", "))}}
+	}
+
+	var issues []Issue
+	var app App
+	for i := 0; i+1 < len(metadata.Content); i += 2 {
+		key := metadata.Content[i].Value
+		if key == "config" {
+			continue
+		}
+
+		field := &yaml.Node{Kind: yaml.MappingNode, Content: metadata.Content[i : i+2]}
+		if err := decodeJSON(field, &app); err != nil {
+			issues = append(issues, doc.Issue(SeverityError, err.Error(), key))
+		}
+	}
+
+	if app.Name == "" {
+		issues = append(issues, doc.Issue(SeverityError, "the app has no name"))
+	}
+	if app.Label == "" {
+		issues = append(issues, doc.Issue(SeverityWarning, "the app has no label"))
+	}
+
+	config := map[string]api.KraudAppTemplateConfig{}
+	if n := doc.Node("config"); n != nil && !isNull(n) {
+		n = resolve(n)
+		if n.Kind != yaml.MappingNode {
+			issues = append(issues, doc.Issue(SeverityError, "config must be a mapping", "config"))
+		}
+
+		for i := 0; n.Kind == yaml.MappingNode && i+1 < len(n.Content); i += 2 {
+			name := n.Content[i].Value
+
+			var c api.KraudAppTemplateConfig
+			if err := decodeJSON(n.Content[i+1], &c); err != nil {
+				issues = append(issues, doc.Issue(SeverityError, err.Error(), "config", name))
+				continue
+			}
+			config[name] = c
+		}
+	}
+
+	for _, name := range sortedKeys(config) {
+		c := config[name]
+		path := []string{"config", name}
+
+		switch c.Kind {
+		case "":
+			issues = append(issues, doc.Issue(SeverityError, "the variable has no kind", path...))
+		case AppConfigSelect:
+			if len(c.Options) == 0 {
+				issues = append(issues, doc.Issue(SeverityError, "select has no options", path...))
+			}
+		case AppConfigText, AppConfigPassword, AppConfigNumber, AppConfigBool:
+			if len(c.Options) > 0 {
+				issues = append(issues, doc.Issue(SeverityWarning, fmt.Sprintf("options are ignored for kind %s", c.Kind), append(path, "options")...))
+			}
+		}
+
+		if c.Default != "" {
+			if err := CheckAppConfig(c, c.Default); err != nil {
+				issues = append(issues, doc.Issue(SeverityError, fmt.Sprintf("invalid default: %v", err), append(path, "default")...))
+			}
+			if c.Kind == AppConfigPassword {
+				issues = append(issues, doc.Issue(SeverityWarning, "passwords should not have a default", append(path, "default")...))
+			}
+		}
+	}
+
+	// variables in the app metadata itself are not part of the compose file
+	content := slices.Clone(top.Content)
+	for _, key := range appKeys {
+		content = removeKey(content, key)
+	}
+
+	rest, err := yaml.Marshal(&yaml.Node{Kind: yaml.MappingNode, Content: content})
+	if err != nil {
+		return nil, append(issues, errorIssues(err)...)
+	}
+
+	vars, err := envparser.ParseTemplateVars(bytes.NewReader(rest))
+	if err != nil {
+		return nil, append(issues, Issue{Severity: SeverityError, Message: err.Error()})
+	}
+
+	for _, name := range sortedKeys(vars) {
+		if _, ok := config[name]; !ok {
+			issues = append(issues, doc.Issue(SeverityWarning, fmt.Sprintf("variable %s is used but can't be configured", name), "config"))
+		}
+	}
+	for _, name := range sortedKeys(config) {
+		if _, ok := vars[name]; !ok {
+			issues = append(issues, doc.Issue(SeverityWarning, "variable is not used by the template", "config", name))
+		}
+	}
+
+	// the rest is checked as the compose file it is when launched, the nodes keep their positions
+	top.Content = content
+	issues = append(issues, doc.lint(nil)...)
+
+	SortIssues(issues)
+	return doc, issues
+}
+
+// config returns the config variables of the app by their name
+func (a *App) config() map[string]api.KraudAppTemplateConfig {
+	if a.Config == nil {
+		return nil
+	}
+	return a.Config.AdditionalProperties
+}
+
+// Env returns the environment an app template is interpolated with, for the given config values.
+// values that are not set use the default of their config variable
+func (a *App) Env(values map[string]string) (map[string]string, error) {
+	config := a.config()
+	env := map[string]string{}
+	var errs []string
+
+	for _, name := range sortedKeys(values) {
+		if _, ok := config[name]; !ok {
+			errs = append(errs, fmt.Sprintf("%s is not a config variable of the app", name))
+		}
+	}
+
+	for _, name := range sortedKeys(config) {
+		c := config[name]
+
+		value, ok := values[name]
+		if !ok || value == "" {
+			value = c.Default
+		}
+
+		if value == "" {
+			if c.Required {
+				errs = append(errs, fmt.Sprintf("%s is required", name))
+			}
+			continue
+		}
+
+		if err := CheckAppConfig(c, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+
+		env[name] = value
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %s", strings.Join(errs, ", "))
+	}
+
+	return env, nil
+}
+
+// RenderApp applies config values to an app template, like launching the app would,
+// and returns the resulting compose file without the app metadata
+func RenderApp(template []byte, values map[string]string) ([]byte, error) {
+	app, err := ParseApp(template)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := app.Env(values)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(template, &doc); err != nil {
+		return nil, err
+	}
+	for _, key := range appKeys {
+		doc.Content[0].Content = removeKey(doc.Content[0].Content, key)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return envparser.Interpolate(buf.Bytes(), env)
+}
+
+// removeKey removes key from the content of a mapping node
+func removeKey(content []*yaml.Node, key string) []*yaml.Node {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == key {
+			if i+2 < len(content) && content[i+2].HeadComment == "" {
+				content[i+2].HeadComment = content[i].HeadComment
+			}
+			return append(content[:i:i], content[i+2:]...)
+		}
+	}
+	return content
+}
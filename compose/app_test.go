@@ -0,0 +1,231 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kraudcloud/cli/api"
+)
+
+const appComposeFile = `# my app
+services:
+  web:
+    image: nginx:${TAG:-latest}
+    ports:
+      - "${PORT:-8080}:80"
+    environment:
+      DB_PASSWORD: ${DB_PASSWORD:?set a database password}
+      API_TOKEN: ${API_TOKEN:?}
+      DEBUG: ${DEBUG:-false}
+      HOST: ${HOST}
+      URL: ${URL:-http://${HOST}}
+      EXTRA: ${EXTRA:+--extra}
+`
+
+func TestNewAppTemplate(t *testing.T) {
+	out, err := NewAppTemplate(App{Name: "web", Label: "Web"}, []byte(appComposeFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(out), "# my app\nname: web\nlabel: Web\n") {
+		t.Errorf("expected the app metadata first, below the comment:\n%s", out)
+	}
+
+	f, err := Parse(strings.NewReader(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := f.Services["web"]; !ok {
+		t.Fatalf("expected the services to be kept")
+	}
+
+	app, err := ParseApp(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &App{
+		Name:  "web",
+		Label: "Web",
+		Config: &api.KraudAppOverview_Config{AdditionalProperties: map[string]api.KraudAppTemplateConfig{
+			"TAG":         {Label: "Tag", Kind: AppConfigText, Default: "latest"},
+			"PORT":        {Label: "Port", Kind: AppConfigNumber, Default: "8080"},
+			"DB_PASSWORD": {Label: "Db password", Kind: AppConfigPassword, Required: true, Description: "set a database password"},
+			"API_TOKEN":   {Label: "Api token", Kind: AppConfigPassword, Required: true},
+			"DEBUG":       {Label: "Debug", Kind: AppConfigBool, Default: "false"},
+			"HOST":        {Label: "Host", Kind: AppConfigText, Required: true},
+			"URL":         {Label: "Url", Kind: AppConfigText},
+			"EXTRA":       {Label: "Extra", Kind: AppConfigText},
+		}},
+	}
+
+	if !reflect.DeepEqual(app, want) {
+		t.Errorf("NewAppTemplate() app = %#v, want %#v", app, want)
+	}
+
+	if _, err := NewAppTemplate(App{Name: "web"}, out); err == nil {
+		t.Errorf("expected an error for an app template")
+	}
+}
+
+const appTemplate = `# my app
+name: web
+label: Web
+config:
+  TAG:
+    label: Tag
+    kind: text
+    default: latest
+  REPLICAS:
+    label: Replicas
+    kind: number
+    default: many
+  MODE:
+    label: Mode
+    kind: select
+    default: prod
+    options:
+      - label: Production
+        value: prod
+      - label: Development
+        value: dev
+  DB_PASSWORD:
+    label: Database password
+    kind: password
+    required: true
+    default: hunter2
+  COLOR:
+    label: Color
+    kind: colour
+  UNUSED:
+    label: Unused
+    kind: bool
+    options:
+      - label: Yes
+        value: "true"
+services:
+  web:
+    image: nginx:${TAG}
+    environment:
+      MODE: ${MODE}
+      DB_PASSWORD: ${DB_PASSWORD}
+      COLOR: ${COLOR}
+      HOST: ${HOST:-localhost}
+    unknown: true
+`
+
+func TestLintApp(t *testing.T) {
+	doc, issues := LintApp([]byte(appTemplate))
+	if doc == nil {
+		t.Fatalf("LintApp() returned no document")
+	}
+
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+
+	want := []string{
+		`4:1: warning: config: variable HOST is used but can't be configured`,
+		`9:3: warning: config.REPLICAS: variable is not used by the template`,
+		`12:5: error: config.REPLICAS.default: invalid default: "many" is not a number`,
+		`26:5: warning: config.DB_PASSWORD.default: passwords should not have a default`,
+		`30:3: warning: config.UNUSED: variable is not used by the template`,
+		`33:5: warning: config.UNUSED.options: options are ignored for kind bool`,
+		`44:5: error: services.web.unknown: unknown key "unknown"`,
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LintApp() = %#v, want %#v", got, want)
+	}
+
+	if _, ok := doc.File.Services["web"]; !ok {
+		t.Errorf("expected the compose file to be decoded")
+	}
+
+	_, issues = LintApp([]byte(appComposeFile))
+	if len(issues) != 1 || issues[0].Severity != SeverityError {
+		t.Errorf("LintApp() = %v, want an error for a compose file", issues)
+	}
+}
+
+func TestRenderApp(t *testing.T) {
+	template := []byte(`# my app
+name: web
+label: Web
+config:
+  TAG:
+    label: Tag
+    kind: text
+    default: latest
+  PORT:
+    label: Port
+    kind: number
+    required: true
+services:
+  web:
+    image: nginx:${TAG}
+    ports:
+      - "${PORT}:80"
+`)
+
+	got, err := RenderApp(template, map[string]string{"PORT": "8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# my app
+name: web
+services:
+  web:
+    image: nginx:latest
+    ports:
+      - "8080:80"
+`
+	if string(got) != want {
+		t.Errorf("RenderApp() = %s, want %s", got, want)
+	}
+
+	for _, values := range []map[string]string{
+		{},
+		{"PORT": "http"},
+		{"PORT": "8080", "OTHER": "1"},
+	} {
+		if _, err := RenderApp(template, values); err == nil {
+			t.Errorf("RenderApp(%v) expected an error", values)
+		}
+	}
+}
+
+func TestMarshalApp(t *testing.T) {
+	app := App{
+		Name:        "web",
+		Label:       "Web",
+		Description: "a web server",
+		PostLaunch:  "open http://web",
+		Config: &api.KraudAppOverview_Config{AdditionalProperties: map[string]api.KraudAppTemplateConfig{
+			"TAG": {Label: "Tag", Kind: AppConfigText, Default: "latest"},
+		}},
+	}
+
+	out, err := MarshalApp(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(out), "name: web\nlabel: Web\n") {
+		t.Errorf("expected block yaml in the order of the template:\n%s", out)
+	}
+
+	got, err := ParseApp(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, &app) {
+		t.Errorf("ParseApp(MarshalApp()) = %+v, want %+v", got, app)
+	}
+}
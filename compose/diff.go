@@ -0,0 +1,129 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines are shown around changes
+const diffContext = 3
+
+type diffOp struct {
+	kind byte
+	text string
+	// a and b are the 0-based line the op is at in either file
+	a, b int
+}
+
+// Diff returns a unified diff from one template to another, empty if they are the same
+func Diff(fromName string, from []byte, toName string, to []byte) string {
+	ops := diffLines(splitLines(string(from)), splitLines(string(to)))
+
+	var b strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// changes closer than twice the context are in the same hunk
+		end := i
+		for j := i; j < len(ops) && j-end <= 2*diffContext; j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			}
+		}
+
+		stop := end + diffContext + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		hunk := ops[start:stop]
+		var aLen, bLen int
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(hunk[0].a, aLen), hunkRange(hunk[0].b, bLen))
+		for _, op := range hunk {
+			b.WriteByte(op.kind)
+			b.WriteString(op.text)
+			b.WriteByte('\n')
+		}
+
+		i = stop
+	}
+
+	return b.String()
+}
+
+func hunkRange(start int, length int) string {
+	// an empty range starts at the line before it
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the edit script from a to b along their longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', text: a[i], a: i, b: j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', text: a[i], a: i, b: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', text: b[j], a: i, b: j})
+			j++
+		}
+	}
+
+	return ops
+}
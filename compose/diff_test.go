@@ -0,0 +1,46 @@
+package compose
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	from := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+
+	tests := []struct {
+		name string
+		to   string
+		want string
+	}{
+		{
+			name: "same",
+			to:   from,
+			want: "",
+		},
+		{
+			name: "change",
+			to:   "a\nb\nc\nd\nE\nf\ng\nh\ni\nj\nk\nl\nm\n",
+			want: "--- v1\n+++ v2\n@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			name: "separate hunks",
+			to:   "A\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n",
+			want: "--- v1\n+++ v2\n@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n d\n@@ -11,3 +11,4 @@\n k\n l\n m\n+n\n",
+		},
+		{
+			name: "to empty",
+			to:   "",
+			want: "--- v1\n+++ v2\n@@ -1,13 +0,0 @@\n-a\n-b\n-c\n-d\n-e\n-f\n-g\n-h\n-i\n-j\n-k\n-l\n-m\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff("v1", []byte(from), "v2", []byte(tt.to)); got != tt.want {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got, want := Diff("v1", nil, "v2", []byte("a\n")), "--- v1\n+++ v2\n@@ -0,0 +1,1 @@\n+a\n"; got != want {
+		t.Errorf("Diff() = %q, want %q", got, want)
+	}
+}
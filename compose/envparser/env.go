@@ -7,6 +7,7 @@ import (
 	"io"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Variable is the right hand side of an environment variable expression
@@ -21,18 +22,30 @@ type Variable struct {
 	// Short form is the form without braces
 	Short bool
 
-	// If Default is empty, and the value is not set, then the value is defaulted
+	// If Default is empty, and the value is not set, then the value is defaulted.
+	// the default may contain variable expressions itself
 	Default string
+	// EmptyDefault is true for ${VAR-}, which is empty rather than non-existent if the value is not set.
+	// ${VAR:-} is UnsetEmpty instead
+	EmptyDefault bool
 	// If Error is not empty, and the value is not set, then the error is returned
 	Error string
+	// If Alternate is not empty, it replaces the value if the value is set
+	Alternate string
 }
 
+// splitVar is a bufio.SplitFunc returning the variable expressions in a template,
+// such as $VAR or ${VAR:-default}. nested expressions are part of the outer token
 func splitVar(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if atEOF && len(data) == 0 {
 		return 0, nil, nil
 	}
 
 	if len(data) < 2 {
+		// a $ at the end of the buffer may start an expression
+		if data[0] == '$' && !atEOF {
+			return 0, nil, nil
+		}
 		return len(data), nil, nil
 	}
 
@@ -51,64 +64,279 @@ func splitVar(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		return 2, nil, nil
 	}
 
-	// long form
+	// long form, up to the matching brace
 	if data[1] == '{' {
-		i := bytes.IndexAny(data, "}\n")
-		if i == -1 || data[i] != '}' {
-			return len(data), nil, fmt.Errorf("unmatched brace in expression %q", data)
+		depth := 0
+		for i := 0; i < len(data); i++ {
+			switch {
+			case data[i] == '\n':
+				return len(data), nil, fmt.Errorf("unmatched brace in expression %q", data[:i])
+			case data[i] == '$' && i+1 < len(data) && data[i+1] == '$':
+				i++
+			case data[i] == '$' && i+1 < len(data) && data[i+1] == '{':
+				depth++
+				i++
+			case data[i] == '}':
+				depth--
+				if depth == 0 {
+					return i + 1, data[:i+1], nil
+				}
+			}
+		}
+
+		if !atEOF {
+			return 0, nil, nil
 		}
 
-		return i + 1, data[:i+1], nil
+		return len(data), nil, fmt.Errorf("unmatched brace in expression %q", data)
+	}
+
+	// a $ not followed by a name is literal
+	if r, _ := utf8.DecodeRune(data[1:]); !isNameStart(r) {
+		return 1, nil, nil
 	}
 
 	// short form
 	i := bytes.IndexFunc(data[1:], func(r rune) bool {
-		return !unicode.IsDigit(r) && !unicode.IsLetter(r) && r != '_'
+		return !isName(r)
 	})
 	if i == -1 {
+		if !atEOF {
+			return 0, nil, nil
+		}
 		return len(data), data, nil
 	}
 
 	return i + 1, data[:i+1], nil
 }
 
-func parseVar(line []byte) Variable {
-	if line[1] != '{' {
-		return Variable{
-			Name:  string(line[1:]),
-			Short: true,
-		}
+func isNameStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isName(r rune) bool {
+	return unicode.IsDigit(r) || unicode.IsLetter(r) || r == '_'
+}
+
+// expression is a parsed variable expression, such as $VAR, ${VAR} or ${VAR:-default}
+type expression struct {
+	name  string
+	short bool
+
+	// colon is set for the :-, :? and :+ operators, which treat empty values like unset ones
+	colon bool
+
+	// op is '-' for defaults, '?' for errors, '+' for alternates or 0 without an operator
+	op byte
+
+	// raw is the operand as written, word is the operand parsed,
+	// it may contain expressions itself
+	raw  string
+	word []segment
+}
+
+// segment is either literal text or an expression
+type segment struct {
+	text string
+	expr *expression
+}
+
+// parseExpression parses a token returned by splitVar
+func parseExpression(token string) (*expression, error) {
+	if !strings.HasPrefix(token, "${") {
+		return &expression{name: token[1:], short: true}, nil
 	}
 
-	line = bytes.Trim(line, "${}")
+	inner := strings.TrimSuffix(token[2:], "}")
 
-	// string of the form `var:-default` or `var:?error` or `var` or no `:`
-	found := bytes.IndexAny(line, "?-:")
-	if found == -1 {
-		return Variable{Name: string(line)}
+	end := strings.IndexFunc(inner, func(r rune) bool { return !isName(r) })
+	if end == -1 {
+		end = len(inner)
 	}
 
-	v := Variable{Name: string(line[:found])}
-	line = line[found:]
+	e := &expression{name: inner[:end]}
+	if r, _ := utf8.DecodeRuneInString(e.name); !isNameStart(r) {
+		return nil, fmt.Errorf("invalid variable name in %q", token)
+	}
+
+	rest := inner[end:]
+	if rest == "" {
+		return e, nil
+	}
 
-	// ignore :
-	if len(line) > 1 && line[0] == ':' {
-		v.UnsetEmpty = true
-		line = line[1:]
+	if rest[0] == ':' {
+		e.colon = true
+		rest = rest[1:]
 	}
 
-	if line[0] == '-' {
-		v.Default = strings.Trim(string(line[1:]), `"' `)
+	if rest == "" || !strings.ContainsRune("-?+", rune(rest[0])) {
+		return nil, fmt.Errorf("invalid operator in %q", token)
+	}
+
+	e.op = rest[0]
+	e.raw = rest[1:]
+
+	var err error
+	e.word, err = parseSegments(e.raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// parseSegments splits a template into literal text and expressions
+func parseSegments(template string) ([]segment, error) {
+	var segments []segment
+	var text strings.Builder
+
+	for len(template) > 0 {
+		i := strings.IndexByte(template, '$')
+		if i == -1 {
+			text.WriteString(template)
+			break
+		}
+
+		text.WriteString(template[:i])
+		template = template[i:]
+
+		advance, token, err := splitVar([]byte(template), true)
+		if err != nil {
+			return nil, err
+		}
+
+		if token == nil {
+			if strings.HasPrefix(template, "$$") {
+				text.WriteByte('$')
+			} else {
+				text.WriteString(template[:advance])
+			}
+
+			template = template[advance:]
+			continue
+		}
+
+		e, err := parseExpression(string(token))
+		if err != nil {
+			return nil, err
+		}
+
+		if text.Len() > 0 {
+			segments = append(segments, segment{text: text.String()})
+			text.Reset()
+		}
+
+		segments = append(segments, segment{expr: e})
+		template = template[advance:]
 	}
 
-	if line[0] == '?' {
-		v.Error = strings.Trim(string(line[1:]), `"' `)
+	if text.Len() > 0 {
+		segments = append(segments, segment{text: text.String()})
+	}
+
+	return segments, nil
+}
+
+// variable returns the variable an expression refers to, without nested variables
+func (e *expression) variable() Variable {
+	v := Variable{
+		Name:       e.name,
+		Short:      e.short,
+		UnsetEmpty: e.colon,
+	}
+
+	switch e.op {
+	case '-':
+		v.Default = e.raw
+		v.EmptyDefault = e.raw == "" && !e.colon
+	case '?':
+		v.Error = e.raw
+		if v.Error == "" {
+			v.Error = fmt.Sprintf("required variable %s is missing a value", e.name)
+		}
+	case '+':
+		v.Alternate = e.raw
 	}
 
 	return v
 }
 
-// ParseTemplateVars returns a map of environment variables that are referenced in the given reader
+// walk calls fn for the expression and all expressions nested in it
+func (e *expression) walk(fn func(*expression)) {
+	fn(e)
+
+	for _, s := range e.word {
+		if s.expr != nil {
+			s.expr.walk(fn)
+		}
+	}
+}
+
+// lookupFunc returns the value of a variable, and whether it is set
+type lookupFunc func(name string) (string, bool)
+
+// eval evaluates an expression like docker compose does
+func (e *expression) eval(lookup lookupFunc) (string, error) {
+	value, ok := lookup(e.name)
+	set := ok && (!e.colon || value != "")
+
+	switch e.op {
+	case '-':
+		if !set {
+			return evalSegments(e.word, lookup)
+		}
+	case '?':
+		if !set {
+			msg, err := evalSegments(e.word, lookup)
+			if err != nil {
+				return "", err
+			}
+			if msg == "" {
+				return "", fmt.Errorf("required variable %s is missing a value", e.name)
+			}
+			return "", fmt.Errorf("required variable %s is missing a value: %s", e.name, msg)
+		}
+	case '+':
+		if set {
+			return evalSegments(e.word, lookup)
+		}
+		return "", nil
+	}
+
+	return value, nil
+}
+
+func evalSegments(segments []segment, lookup lookupFunc) (string, error) {
+	var out strings.Builder
+
+	for _, s := range segments {
+		if s.expr == nil {
+			out.WriteString(s.text)
+			continue
+		}
+
+		value, err := s.expr.eval(lookup)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+	}
+
+	return out.String(), nil
+}
+
+// expand evaluates the expressions in template
+func expand(template string, lookup lookupFunc) (string, error) {
+	segments, err := parseSegments(template)
+	if err != nil {
+		return "", err
+	}
+
+	return evalSegments(segments, lookup)
+}
+
+// ParseTemplateVars returns a map of environment variables that are referenced in the given reader,
+// including variables nested in the operands of other expressions
 //
 // The map key is the variable name, and the value is the right hand side of the expression
 func ParseTemplateVars(r io.Reader) (map[string]Variable, error) {
@@ -118,8 +346,14 @@ func ParseTemplateVars(r io.Reader) (map[string]Variable, error) {
 	br.Split(splitVar)
 
 	for br.Scan() {
-		v := parseVar(br.Bytes())
-		vars[v.Name] = v
+		e, err := parseExpression(br.Text())
+		if err != nil {
+			return nil, fmt.Errorf("invalid variable: %s", err)
+		}
+
+		e.walk(func(e *expression) {
+			vars[e.name] = e.variable()
+		})
 	}
 
 	if err := br.Err(); err != nil {
@@ -129,3 +363,18 @@ func ParseTemplateVars(r io.Reader) (map[string]Variable, error) {
 	return vars, nil
 
 }
+
+// Interpolate replaces the variable expressions in template with their values from env,
+// following the interpolation rules of the compose specification.
+// variables missing from env are replaced with their default, or nothing
+func Interpolate(template []byte, env map[string]string) ([]byte, error) {
+	out, err := expand(string(template), func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(out), nil
+}
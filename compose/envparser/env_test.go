@@ -133,12 +133,12 @@ func TestFindTemplateVars(t *testing.T) {
       `,
 			out: map[string]Variable{
 				"INGRESS": {
-					Default:    "https://umami.*",
+					Default:    `"https://umami.*"`,
 					Name:       "INGRESS",
 					UnsetEmpty: true,
 				},
 				"INGRESS2": {
-					Error: "https://umami.*",
+					Error: `'https://umami.*'`,
 					Name:  "INGRESS2",
 				},
 				"INGRESS3": {
@@ -154,6 +154,42 @@ func TestFindTemplateVars(t *testing.T) {
 			out:   map[string]Variable{},
 			error: "invalid variable",
 		},
+		{
+			name:  "bad name",
+			in:    `${FOO BAR}`,
+			out:   map[string]Variable{},
+			error: "invalid variable",
+		},
+		{
+			name: "nested",
+			in:   `${FOO:-${BAR:-baz}} ${ALT:+$OTHER}`,
+			out: map[string]Variable{
+				"FOO": {
+					Name:       "FOO",
+					Default:    "${BAR:-baz}",
+					UnsetEmpty: true,
+				},
+				"BAR": {
+					Name:       "BAR",
+					Default:    "baz",
+					UnsetEmpty: true,
+				},
+				"ALT": {
+					Name:       "ALT",
+					Alternate:  "$OTHER",
+					UnsetEmpty: true,
+				},
+				"OTHER": {
+					Name:  "OTHER",
+					Short: true,
+				},
+			},
+		},
+		{
+			name: "lone dollar",
+			in:   `price: $ 5 or $5`,
+			out:  map[string]Variable{},
+		},
 	}
 
 	for _, tc := range tests {
@@ -328,6 +364,39 @@ func Test_splitVar(t *testing.T) {
 				"${FOO-BAR_BAZ}",
 			},
 		},
+		{
+			name: "nested",
+			args: args{
+				data:  []byte("image: ${FOO:-${BAR:-baz}}:latest"),
+				atEOF: false,
+			},
+			collected: []string{"${FOO:-${BAR:-baz}}"},
+		},
+		{
+			name: "nested with escaped $",
+			args: args{
+				data:  []byte("cmd: ${FOO:-$$BAR} $BAZ"),
+				atEOF: false,
+			},
+			collected: []string{"${FOO:-$$BAR}", "$BAZ"},
+		},
+		{
+			name: "lone $",
+			args: args{
+				data:  []byte("price: $ 5 or $5 $"),
+				atEOF: false,
+			},
+			collected: []string{},
+		},
+		{
+			name: "unmatched nested brace",
+			args: args{
+				data:  []byte("${FOO:-${BAR}"),
+				atEOF: false,
+			},
+			collected: []string{},
+			error:     "unmatched brace",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -355,11 +424,12 @@ func Test_splitVar(t *testing.T) {
 	}
 }
 
-func Test_parseVar(t *testing.T) {
+func Test_parseExpression(t *testing.T) {
 	tests := []struct {
-		name string
-		line string
-		want Variable
+		name  string
+		line  string
+		want  Variable
+		error bool
 	}{
 		{
 			name: "simple",
@@ -393,6 +463,23 @@ func Test_parseVar(t *testing.T) {
 				UnsetEmpty: true,
 			},
 		},
+		{
+			name: "quoted default with spaces",
+			line: `${X:-"a b"}`,
+			want: Variable{
+				Name:       "X",
+				Default:    `"a b"`,
+				UnsetEmpty: true,
+			},
+		},
+		{
+			name: "empty default no :",
+			line: "${FOO-}",
+			want: Variable{
+				Name:         "FOO",
+				EmptyDefault: true,
+			},
+		},
 		{
 			name: "default no :",
 			line: "${FOO-BAR BAZ}",
@@ -401,12 +488,78 @@ func Test_parseVar(t *testing.T) {
 				Default: "BAR BAZ",
 			},
 		},
+		{
+			name: "alternate",
+			line: "${FOO+BAR}",
+			want: Variable{
+				Name:      "FOO",
+				Alternate: "BAR",
+			},
+		},
+		{
+			name: "alternate with :",
+			line: "${FOO:+BAR}",
+			want: Variable{
+				Name:       "FOO",
+				Alternate:  "BAR",
+				UnsetEmpty: true,
+			},
+		},
+		{
+			name: "nested default",
+			line: "${FOO:-${BAR:-baz}}",
+			want: Variable{
+				Name:       "FOO",
+				Default:    "${BAR:-baz}",
+				UnsetEmpty: true,
+			},
+		},
+		{
+			name: "default with escaped $",
+			line: "${FOO:-$$BAR}",
+			want: Variable{
+				Name:       "FOO",
+				Default:    "$$BAR",
+				UnsetEmpty: true,
+			},
+		},
+		{
+			name: "error without message",
+			line: "${FOO?}",
+			want: Variable{
+				Name:  "FOO",
+				Error: "required variable FOO is missing a value",
+			},
+		},
+		{
+			name:  "invalid name",
+			line:  "${FOO BAR}",
+			error: true,
+		},
+		{
+			name:  "invalid operator",
+			line:  "${FOO:bar}",
+			error: true,
+		},
+		{
+			name:  "empty name",
+			line:  "${:-bar}",
+			error: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := parseVar([]byte(tt.line))
+			e, err := parseExpression(tt.line)
+			if (err != nil) != tt.error {
+				t.Fatalf("parseExpression() error = %v, wantErr %v", err, tt.error)
+			}
+			if err != nil {
+				return
+			}
+
+			got := e.variable()
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parseVar() = %v, want %v", got, tt.want)
+				t.Errorf("parseExpression() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -606,3 +759,58 @@ services:
       - TZ=Europe/Berlin
       - PASSWORD=${PASSWORD?password is required}
 `
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{
+		"FOO":   "foo",
+		"EMPTY": "",
+	}
+
+	tests := []struct {
+		name  string
+		in    string
+		out   string
+		error bool
+	}{
+		{name: "plain", in: "image: nginx", out: "image: nginx"},
+		{name: "short", in: "image: $FOO", out: "image: foo"},
+		{name: "long", in: "image: ${FOO}:latest", out: "image: foo:latest"},
+		{name: "default", in: "tag: ${TAG:-latest}", out: "tag: latest"},
+		{name: "set empty", in: "tag: ${EMPTY-latest}", out: "tag: "},
+		{name: "unset", in: "tag: ${TAG}", out: "tag: "},
+		{name: "escaped", in: "cmd: echo $$HOME", out: "cmd: echo $HOME"},
+		{name: "trailing dollar", in: "price: 5$", out: "price: 5$"},
+		{name: "lone dollar", in: "price: $ 5", out: "price: $ 5"},
+		{name: "multiple", in: "$FOO-${FOO}\n$FOO", out: "foo-foo\nfoo"},
+		{name: "unmatched brace", in: "image: ${FOO", error: true},
+		{name: "empty default", in: "tag: ${EMPTY:-latest}", out: "tag: latest"},
+		{name: "unset default", in: "tag: ${TAG-latest}", out: "tag: latest"},
+		{name: "alternate", in: "${FOO+set} ${TAG+set} ${EMPTY+set}", out: "set  set"},
+		{name: "alternate with :", in: "${FOO:+set} ${TAG:+set} ${EMPTY:+set}", out: "set  "},
+		{name: "alternate with variable", in: "${FOO:+--name=$FOO}", out: "--name=foo"},
+		{name: "nested default", in: "${TAG:-${OTHER:-${FOO}}}", out: "foo"},
+		{name: "nested unused", in: "${FOO:-${MISSING?required}}", out: "foo"},
+		{name: "escaped in default", in: "${TAG:-$$HOME}", out: "$HOME"},
+		{name: "escaped in the middle", in: "a$$b$${FOO}c$FOO", out: "a$b${FOO}cfoo"},
+		{name: "required", in: "${FOO?missing} ${FOO:?missing}", out: "foo foo"},
+		{name: "required empty", in: "${EMPTY?missing}", out: ""},
+		{name: "required empty with :", in: "${EMPTY:?missing}", error: true},
+		{name: "required unset", in: "${TAG?missing}", error: true},
+		{name: "required nested", in: "${TAG:-${OTHER:?missing}}", error: true},
+		{name: "digit is not a name", in: "$1 ${FOO}", out: "$1 foo"},
+		{name: "invalid name", in: "${FOO BAR}", error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Interpolate([]byte(tt.in), env)
+			if (err != nil) != tt.error {
+				t.Fatalf("Interpolate() error = %v, wantErr %v", err, tt.error)
+			}
+
+			if !tt.error && string(got) != tt.out {
+				t.Errorf("Interpolate() = %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
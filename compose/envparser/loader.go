@@ -4,49 +4,96 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
 	"sort"
 	"strings"
-	"sync"
+	"unicode"
 
 	"golang.org/x/exp/maps"
 )
 
 type EnvLoader func(string) *string
 
-// LoadEnv loads the environment variables from the given reader
-// The loaders function is used to load the value of an environment variable
+// Source is a named EnvLoader, so LoadEnv can report where a value came from
+type Source struct {
+	Name string
+	Load EnvLoader
+}
+
+// Names of the sources LoadEnv reports for values that no Source supplied
+const (
+	SourceDefault = "default"
+	SourceUnset   = "unset"
+)
+
+// Loaded is a loaded environment variable and where its value came from
+type Loaded struct {
+	Value string `json:"value"`
+
+	// Source is the name of the Source that supplied the value, SourceDefault or SourceUnset
+	Source string `json:"source"`
+
+	// Conflicts are the names of the other sources defining the variable with a different value
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+// Env is a loaded environment with the provenance of each variable
+type Env map[string]Loaded
+
+// Values returns the values of the variables in e
+func (e Env) Values() map[string]string {
+	values := make(map[string]string, len(e))
+	for k, v := range e {
+		values[k] = v.Value
+	}
+	return values
+}
+
+// LoadEnv loads the environment variables in toLoad from sources
+//
+// first-non-nil source wins
+// if no source is non-nil, then the default is used, or an error is returned.
 //
-// first-non-nil loader wins
-// if no loader is non-nil, then the default is used, or an error is returned
-func LoadEnv(toLoad map[string]Variable, loaders ...EnvLoader) (map[string]string, error) {
+// with UnsetEmpty, for the :-, :? and :+ forms, an empty value counts as not set like docker compose does:
+// the default or error is checked before the empty value is used, so ${FOO:-bar} with FOO= is bar
+// and ${FOO:?} with FOO= is an error. without a default or error the variable is loaded empty
+func LoadEnv(toLoad map[string]Variable, sources ...Source) (Env, error) {
 	var errs []string
 
-	vars := make(map[string]string)
-	for k, v := range toLoad {
-		outV := ""
-		for _, loader := range loaders {
-			if loader == nil {
+	find := func(name string) (string, string, bool) {
+		for _, source := range sources {
+			if source.Load == nil {
 				continue
 			}
 
-			if v := loader(k); v != nil {
-				outV = *v
-				break
+			if v := source.Load(name); v != nil {
+				return *v, source.Name, true
 			}
 		}
+		return "", "", false
+	}
 
-		if v.UnsetEmpty && outV == "" {
-			vars[k] = outV
-			continue
-		}
+	lookup := func(name string) (string, bool) {
+		value, _, ok := find(name)
+		return value, ok
+	}
 
-		if outV != "" {
-			vars[k] = outV
+	vars := make(Env)
+	for k, v := range toLoad {
+		value, source, ok := find(k)
+		if ok && (value != "" || !v.UnsetEmpty) {
+			vars[k] = Loaded{Value: value, Source: source, Conflicts: conflicts(sources, k, value)}
 			continue
 		}
 
 		if v.Default != "" {
-			vars[k] = v.Default
+			value, err := expand(v.Default, lookup)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+
+			vars[k] = Loaded{Value: value, Source: SourceDefault}
 			continue
 		}
 
@@ -54,15 +101,72 @@ func LoadEnv(toLoad map[string]Variable, loaders ...EnvLoader) (map[string]strin
 			errs = append(errs, v.Error)
 			continue
 		}
+
+		if v.UnsetEmpty {
+			if !ok {
+				source = SourceUnset
+			}
+			vars[k] = Loaded{Value: value, Source: source}
+			continue
+		}
 	}
 
 	if len(errs) > 0 {
+		sort.Strings(errs)
 		return nil, fmt.Errorf("missing environment variables: %s", strings.Join(errs, ", "))
 	}
 
 	return vars, nil
 }
 
+// conflicts returns the names of the sources that define name with a value other than value
+func conflicts(sources []Source, name string, value string) []string {
+	var names []string
+	for _, source := range sources {
+		if source.Load == nil {
+			continue
+		}
+
+		if v := source.Load(name); v != nil && *v != value {
+			names = append(names, source.Name)
+		}
+	}
+	return names
+}
+
+// Undefined returns the sorted names of the variables in toLoad that have no value in loaded and no default.
+// variables that are only used for an alternate value may be unset, and ${VAR-} and ${VAR:-} count as an empty default
+func Undefined(toLoad map[string]Variable, loaded Env) []string {
+	var names []string
+	for k, v := range toLoad {
+		if v.Alternate != "" || v.UnsetEmpty || v.EmptyDefault {
+			continue
+		}
+
+		if _, ok := loaded[k]; ok {
+			continue
+		}
+
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Unused returns the sorted names of the variables in defined that toLoad doesn't reference
+func Unused(toLoad map[string]Variable, defined map[string]string) []string {
+	var names []string
+	for k := range defined {
+		if _, ok := toLoad[k]; !ok {
+			names = append(names, k)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
 // LoadKV returns a loader function that loads the given key/value pairs from the map
 func LoadKV(kv map[string]string) EnvLoader {
 	return func(key string) *string {
@@ -99,33 +203,260 @@ func ParseKV(kv string) (string, string, error) {
 	return k, v, nil
 }
 
-// LoadEnvReader reads a .env formatted file from the given path, and returns a loader function
-func LoadEnvReader(r io.Reader) EnvLoader {
-	return LoadKV(EnvMapFromReader(r))
+// LoadEnvReader reads a .env formatted file from the given reader, and returns a loader function
+func LoadEnvReader(r io.Reader) (EnvLoader, error) {
+	env, err := EnvMapFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadKV(env), nil
 }
 
-// EnvMapFromReader reads a .env formatted file from the given path, and returns a map of key/value pairs
-func EnvMapFromReader(r io.Reader) map[string]string {
-	sr := bufio.NewScanner(r)
-	sr.Split(bufio.ScanLines)
+// EnvMapFromReader reads a .env formatted file from the given reader, and returns a map of key/value pairs.
+// variables in values are expanded with keys defined earlier in the file, then the process environment
+func EnvMapFromReader(r io.Reader) (map[string]string, error) {
+	return ParseEnvFile(r, os.LookupEnv)
+}
+
+// ParseEnvFile parses a .env file like docker compose does:
+//
+//   - empty lines and lines starting with # are ignored
+//   - keys may be prefixed with export
+//   - unquoted values end at the end of the line or at a # preceded by whitespace, and are trimmed
+//   - single quoted values are taken literally and may span lines
+//   - double quoted values may span lines and support the \n, \r, \t, \\, \" and \$ escapes
+//   - variables in unquoted and double quoted values are expanded with keys defined earlier in the file, then lookup
+//
+// errors include the line they occurred on
+func ParseEnvFile(r io.Reader, lookup func(string) (string, bool)) (map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &envFileParser{
+		src:  strings.ReplaceAll(string(data), "\r\n", "\n"),
+		line: 1,
+		vars: map[string]string{},
+	}
+
+	p.lookup = func(name string) (string, bool) {
+		if v, ok := p.vars[name]; ok {
+			return v, true
+		}
+		if lookup != nil {
+			return lookup(name)
+		}
+		return "", false
+	}
+
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.src) {
+			return p.vars, nil
+		}
 
-	vars := make(map[string]string)
-	for sr.Scan() {
-		line := sr.Text()
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "#") {
+		if err := p.parseLine(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+	}
+}
+
+type envFileParser struct {
+	src    string
+	pos    int
+	line   int
+	vars   map[string]string
+	lookup lookupFunc
+}
+
+// skipBlank skips whitespace, empty lines and comments
+func (p *envFileParser) skipBlank() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '\n':
+			p.line++
+		case ' ', '\t':
+		case '#':
+			p.skipLine()
 			continue
+		default:
+			return
 		}
+		p.pos++
+	}
+}
 
-		k, v, err := ParseKV(line)
-		if err != nil {
+// skipLine moves to the newline ending the current line
+func (p *envFileParser) skipLine() {
+	if i := strings.IndexByte(p.src[p.pos:], '\n'); i != -1 {
+		p.pos += i
+	} else {
+		p.pos = len(p.src)
+	}
+}
+
+// restOfLine returns the rest of the current line and moves to its newline
+func (p *envFileParser) restOfLine() string {
+	start := p.pos
+	p.skipLine()
+	return p.src[start:p.pos]
+}
+
+func (p *envFileParser) parseLine() error {
+	line := p.src[p.pos:]
+	if i := strings.IndexByte(line, '\n'); i != -1 {
+		line = line[:i]
+	}
+
+	eq := strings.IndexByte(line, '=')
+	if eq == -1 {
+		return fmt.Errorf("missing = after %q", strings.TrimSpace(line))
+	}
+
+	key := strings.TrimSpace(line[:eq])
+	if rest, ok := strings.CutPrefix(key, "export"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+		key = strings.TrimSpace(rest)
+	}
+	key = unescapeStringVar(key)
+
+	if !isValidKey(key) {
+		return fmt.Errorf("invalid variable name %q", key)
+	}
+
+	p.pos += eq + 1
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+
+	var value string
+	var err error
+
+	switch {
+	case p.pos < len(p.src) && p.src[p.pos] == '\'':
+		value, err = p.parseSingleQuoted()
+	case p.pos < len(p.src) && p.src[p.pos] == '"':
+		value, err = p.parseDoubleQuoted()
+	default:
+		value, err = p.parseUnquoted(p.pos > 0 && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t'))
+	}
+	if err != nil {
+		return err
+	}
+
+	p.vars[key] = value
+	return nil
+}
+
+func (p *envFileParser) parseSingleQuoted() (string, error) {
+	start := p.pos + 1
+	end := strings.IndexByte(p.src[start:], '\'')
+	if end == -1 {
+		return "", fmt.Errorf("unterminated single quoted value")
+	}
+
+	value := p.src[start : start+end]
+	p.line += strings.Count(value, "\n")
+	p.pos = start + end + 1
+
+	return value, p.endOfValue()
+}
+
+func (p *envFileParser) parseDoubleQuoted() (string, error) {
+	var value strings.Builder
+	line := p.line
+
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+
+		switch {
+		case c == '"':
+			p.pos++
+
+			// escaped dollars are kept as $$, so expand turns them into $
+			expanded, err := expand(value.String(), p.lookup)
+			if err != nil {
+				return "", err
+			}
+
+			return expanded, p.endOfValue()
+
+		case c == '\\' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '$':
+				value.WriteString("$$")
+			case '\\', '"':
+				value.WriteByte(e)
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(e)
+			}
+
+		case c == '\n':
+			p.line++
+			value.WriteByte(c)
+
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	p.line = line
+	return "", fmt.Errorf("unterminated double quoted value")
+}
+
+// parseUnquoted parses a value up to the end of the line.
+// a # starts a comment if it follows whitespace, which includes the whitespace after the =
+func (p *envFileParser) parseUnquoted(afterBlank bool) (string, error) {
+	value := p.restOfLine()
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '#' {
 			continue
 		}
 
-		vars[k] = v
+		if (i == 0 && afterBlank) || (i > 0 && (value[i-1] == ' ' || value[i-1] == '\t')) {
+			value = value[:i]
+			break
+		}
 	}
 
-	return vars
+	return expand(strings.TrimSpace(value), p.lookup)
+}
+
+// endOfValue checks that only whitespace or a comment follows a quoted value
+func (p *envFileParser) endOfValue() error {
+	rest := strings.TrimLeft(p.restOfLine(), " \t")
+	if rest != "" && rest[0] != '#' {
+		return fmt.Errorf("unexpected %q after quoted value", rest)
+	}
+
+	return nil
+}
+
+func isValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for i, r := range key {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case i > 0 && (unicode.IsDigit(r) || r == '.' || r == '-'):
+		default:
+			return false
+		}
+	}
+
+	return true
 }
 
 func unescapeStringVar(s string) string {
@@ -142,24 +473,22 @@ func unescapeStringVar(s string) string {
 	return strings.TrimSpace(s)
 }
 
-const toEscape = "\t\n\"'\\$"
-const toQuote = " "
-
-var escapeReplacer interface {
-	Replace(string) string
-}
-var onceReplacer sync.Once
+// toQuote are the characters that make EncodeEnv quote a value
+const toQuote = " \t\n\r\"'\\$#"
+
+// escapeReplacer escapes values in double quotes, so ParseEnvFile reads them back unchanged
+var escapeReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"\"", "\\\"",
+	"$", "\\$",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\t", "\\t",
+)
 
+// EncodeEnv writes env as a .env file, sorted by key.
+// values with special characters are double quoted and escaped
 func EncodeEnv(w io.Writer, env map[string]string) error {
-	onceReplacer.Do(func() {
-		var replacer []string
-		for _, c := range toEscape {
-			replacer = append(replacer, string(c), "\\"+string(c))
-		}
-
-		escapeReplacer = strings.NewReplacer(replacer...)
-	})
-
 	sw := bufio.NewWriter(w)
 
 	sorted := maps.Keys(env)
@@ -172,13 +501,12 @@ func EncodeEnv(w io.Writer, env map[string]string) error {
 
 		sw.WriteString(key)
 		sw.WriteString("=")
-		quote := strings.ContainsAny(value, toQuote)
-		if quote {
+		if strings.ContainsAny(value, toQuote) {
 			sw.WriteByte('"')
-		}
-		sw.WriteString(escapeReplacer.Replace(value))
-		if quote {
+			sw.WriteString(escapeReplacer.Replace(value))
 			sw.WriteByte('"')
+		} else {
+			sw.WriteString(value)
 		}
 		sw.WriteString("\n")
 	}
@@ -2,6 +2,7 @@ package envparser
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"reflect"
 	"strings"
@@ -69,7 +70,10 @@ func TestLoadEnvReader(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotV := LoadEnvReader(tt.r)
+			gotV, err := LoadEnvReader(tt.r)
+			if err != nil {
+				t.Fatalf("LoadEnvReader() error = %v", err)
+			}
 
 			for k, v := range tt.wantV {
 				if got := gotV(k); got != nil && *got != v {
@@ -80,6 +84,155 @@ func TestLoadEnvReader(t *testing.T) {
 	}
 }
 
+func TestParseEnvFile(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "SHELL_VAR" {
+			return "shell", true
+		}
+		return "", false
+	}
+
+	tests := []struct {
+		name  string
+		in    string
+		want  map[string]string
+		error string
+	}{
+		{
+			name: "export",
+			in:   "export FOO=bar\nexport\tBAZ=qux\nexporter=1",
+			want: map[string]string{"FOO": "bar", "BAZ": "qux", "exporter": "1"},
+		},
+		{
+			name: "inline comments",
+			in:   "FOO=bar # comment\nBAZ=q#ux\nEMPTY= # comment\nHASH=#value\nQUOTED=\"a # b\" # comment",
+			want: map[string]string{"FOO": "bar", "BAZ": "q#ux", "EMPTY": "", "HASH": "#value", "QUOTED": "a # b"},
+		},
+		{
+			name: "empty values",
+			in:   "FOO=\nBAR=\"\"\nBAZ=''",
+			want: map[string]string{"FOO": "", "BAR": "", "BAZ": ""},
+		},
+		{
+			name: "multi-line double quoted",
+			in:   "KEY=\"-----BEGIN KEY-----\nabc\n-----END KEY-----\"\nNEXT=1",
+			want: map[string]string{"KEY": "-----BEGIN KEY-----\nabc\n-----END KEY-----", "NEXT": "1"},
+		},
+		{
+			name: "multi-line single quoted",
+			in:   "FOO='a\nb'",
+			want: map[string]string{"FOO": "a\nb"},
+		},
+		{
+			name: "escapes",
+			in:   `FOO="a\nb\tc\\d\"e\$f\x"` + "\n" + `BAR='a\nb'` + "\n" + `BAZ=a\nb`,
+			want: map[string]string{"FOO": "a\nb\tc\\d\"e$f\\x", "BAR": `a\nb`, "BAZ": `a\nb`},
+		},
+		{
+			name: "variables",
+			in:   "HOST=db\nURL=postgres://$HOST:${PORT:-5432}\nQUOTED=\"${HOST}-$SHELL_VAR\"\nLITERAL='$HOST'\nESCAPED=$$HOST",
+			want: map[string]string{
+				"HOST":    "db",
+				"URL":     "postgres://db:5432",
+				"QUOTED":  "db-shell",
+				"LITERAL": "$HOST",
+				"ESCAPED": "$HOST",
+			},
+		},
+		{
+			name: "only earlier keys",
+			in:   "FOO=$BAR\nBAR=bar",
+			want: map[string]string{"FOO": "", "BAR": "bar"},
+		},
+		{
+			name: "windows line endings",
+			in:   "FOO=bar\r\nBAZ=\"qux\"\r\n",
+			want: map[string]string{"FOO": "bar", "BAZ": "qux"},
+		},
+		{
+			name:  "missing =",
+			in:    "FOO=bar\n\nBAZ\n",
+			error: "line 3: missing = after \"BAZ\"",
+		},
+		{
+			name:  "invalid key",
+			in:    "# comment\n1FOO=bar",
+			error: "line 2: invalid variable name \"1FOO\"",
+		},
+		{
+			name:  "unterminated double quote",
+			in:    "FOO=1\nBAR=\"bar\nBAZ=qux",
+			error: "line 2: unterminated double quoted value",
+		},
+		{
+			name:  "unterminated single quote",
+			in:    "FOO='bar",
+			error: "line 1: unterminated single quoted value",
+		},
+		{
+			name:  "text after quoted value",
+			in:    "FOO=\"a\nb\" c",
+			error: "line 2: unexpected \"c\" after quoted value",
+		},
+		{
+			name:  "required variable",
+			in:    "FOO=${BAR:?bar is required}",
+			error: "line 1: required variable BAR is missing a value: bar is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEnvFile(strings.NewReader(tt.in), lookup)
+			if tt.error != "" {
+				if err == nil || err.Error() != tt.error {
+					t.Fatalf("ParseEnvFile() error = %v, want %s", err, tt.error)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseEnvFile() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseEnvFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeEnvRoundTrip(t *testing.T) {
+	env := map[string]string{
+		"EMPTY":    "",
+		"PLAIN":    "bar",
+		"SPACES":   "  a b  ",
+		"QUOTES":   `it's "quoted"`,
+		"DOLLAR":   "pa$$word $HOME ${X}",
+		"HASH":     "a #b",
+		"ESCAPES":  `back\slash \n`,
+		"MULTI":    "line 1\nline 2\r\n\tindented",
+		"EQUALS":   "a=b",
+		"UNICODE":  "grüße",
+		"PEM":      "-----BEGIN-----\nabc\n-----END-----\n",
+		"TRAILING": "value\\",
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeEnv(&buf, env); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ParseEnvFile(&buf, nil)
+	if err != nil {
+		t.Fatalf("ParseEnvFile() error = %v\n%s", err, buf.String())
+	}
+
+	if !reflect.DeepEqual(got, env) {
+		t.Errorf("round trip = %q, want %q", got, env)
+	}
+}
+
 func TestEncodeEnv(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -115,6 +268,14 @@ func TestEncodeEnv(t *testing.T) {
 			},
 			wantW: "BAZ=\"bar dwq dwdw\"\nFOO=bar\n",
 		},
+		{
+			name: "escapes",
+			env: map[string]string{
+				"FOO": "a\nb",
+				"BAR": `say "$HOME"`,
+			},
+			wantW: "BAR=\"say \\\"\\$HOME\\\"\"\nFOO=\"a\\nb\"\n",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -272,6 +433,90 @@ func TestLoadEnv(t *testing.T) {
 				"FOO": "",
 			},
 		},
+		{
+			name: "unset empty with default",
+			toLoad: map[string]Variable{
+				"FOO": {
+					Name:       "FOO",
+					UnsetEmpty: true,
+					Default:    "bar",
+				},
+			},
+			loaders: []EnvLoader{LoadKV(map[string]string{})},
+			want: map[string]string{
+				"FOO": "bar",
+			},
+		},
+		{
+			name: "set empty without :",
+			toLoad: map[string]Variable{
+				"FOO": {
+					Name:    "FOO",
+					Default: "bar",
+				},
+			},
+			loaders: []EnvLoader{LoadKV(map[string]string{
+				"FOO": "",
+			})},
+			want: map[string]string{
+				"FOO": "",
+			},
+		},
+		{
+			name: "set empty with :",
+			toLoad: map[string]Variable{
+				"FOO": {
+					Name:       "FOO",
+					Default:    "bar",
+					UnsetEmpty: true,
+				},
+			},
+			loaders: []EnvLoader{LoadKV(map[string]string{
+				"FOO": "",
+			})},
+			want: map[string]string{
+				"FOO": "bar",
+			},
+		},
+		{
+			name: "nested default",
+			toLoad: map[string]Variable{
+				"FOO": {
+					Name:       "FOO",
+					Default:    "${BAR:-baz}",
+					UnsetEmpty: true,
+				},
+			},
+			loaders: []EnvLoader{LoadKV(map[string]string{
+				"BAR": "qux",
+			})},
+			want: map[string]string{
+				"FOO": "qux",
+			},
+		},
+		{
+			name: "nested default error",
+			toLoad: map[string]Variable{
+				"FOO": {
+					Name:       "FOO",
+					Default:    "${BAR:?bar is required}",
+					UnsetEmpty: true,
+				},
+			},
+			loaders: []EnvLoader{LoadKV(map[string]string{})},
+			wantErr: true,
+		},
+		{
+			name: "alternate",
+			toLoad: map[string]Variable{
+				"FOO": {
+					Name:      "FOO",
+					Alternate: "bar",
+				},
+			},
+			loaders: []EnvLoader{LoadKV(map[string]string{})},
+			want:    map[string]string{},
+		},
 		{
 			name: "nil loader",
 			toLoad: map[string]Variable{
@@ -285,14 +530,142 @@ func TestLoadEnv(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := LoadEnv(tt.toLoad, tt.loaders...)
+			var sources []Source
+			for i, loader := range tt.loaders {
+				sources = append(sources, Source{Name: fmt.Sprint(i), Load: loader})
+			}
+
+			got, err := LoadEnv(tt.toLoad, sources...)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("LoadEnv() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("LoadEnv() = %v, want %v", got, tt.want)
+			if err == nil && !reflect.DeepEqual(got.Values(), tt.want) {
+				t.Errorf("LoadEnv() = %v, want %v", got.Values(), tt.want)
+			}
+		})
+	}
+}
+
+// TestLoadEnvUnsetEmpty pins the order in which an empty value, the default and the error of a variable
+// are considered, and that it agrees with how the template is interpolated
+func TestLoadEnvUnsetEmpty(t *testing.T) {
+	unset := map[string]string{}
+	empty := map[string]string{"FOO": ""}
+	set := map[string]string{"FOO": "foo"}
+
+	tests := []struct {
+		template string
+		env      map[string]string
+		want     string
+		wantErr  bool
+	}{
+		{template: "${FOO:-bar}", env: unset, want: "bar"},
+		{template: "${FOO:-bar}", env: empty, want: "bar"},
+		{template: "${FOO:-bar}", env: set, want: "foo"},
+		{template: "${FOO-bar}", env: unset, want: "bar"},
+		{template: "${FOO-bar}", env: empty, want: ""},
+		{template: "${FOO-bar}", env: set, want: "foo"},
+		{template: "${FOO:?}", env: unset, wantErr: true},
+		{template: "${FOO:?}", env: empty, wantErr: true},
+		{template: "${FOO:?}", env: set, want: "foo"},
+		{template: "${FOO?}", env: unset, wantErr: true},
+		{template: "${FOO?}", env: empty, want: ""},
+		{template: "${FOO:+bar}", env: unset, want: ""},
+		{template: "${FOO:+bar}", env: empty, want: ""},
+		{template: "${FOO:+bar}", env: set, want: "bar"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.template, tt.env), func(t *testing.T) {
+			vars, err := ParseTemplateVars(strings.NewReader(tt.template))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadEnv(vars, Source{Name: "env", Load: LoadKV(tt.env)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			out, err := Interpolate([]byte(tt.template), loaded.Values())
+			if err != nil {
+				t.Fatalf("Interpolate() error = %v", err)
+			}
+
+			if string(out) != tt.want {
+				t.Errorf("got %q, want %q", out, tt.want)
 			}
 		})
 	}
 }
+
+func TestLoadEnvProvenance(t *testing.T) {
+	toLoad := map[string]Variable{
+		"FLAG":     {Name: "FLAG"},
+		"SHARED":   {Name: "SHARED"},
+		"SAME":     {Name: "SAME"},
+		"FILE":     {Name: "FILE"},
+		"DEFAULT":  {Name: "DEFAULT", Default: "${FILE}-default"},
+		"EMPTY":    {Name: "EMPTY", UnsetEmpty: true},
+		"OVERRIDE": {Name: "OVERRIDE", Default: "default"},
+	}
+
+	got, err := LoadEnv(toLoad,
+		Source{Name: "--env", Load: LoadKV(map[string]string{"FLAG": "flag", "SHARED": "flag", "SAME": "same"})},
+		Source{Name: "environment", Load: nil},
+		Source{Name: ".env", Load: LoadKV(map[string]string{"SHARED": "file", "SAME": "same", "FILE": "file", "OVERRIDE": "file"})},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Env{
+		"FLAG":     {Value: "flag", Source: "--env"},
+		"SHARED":   {Value: "flag", Source: "--env", Conflicts: []string{".env"}},
+		"SAME":     {Value: "same", Source: "--env"},
+		"FILE":     {Value: "file", Source: ".env"},
+		"DEFAULT":  {Value: "file-default", Source: SourceDefault},
+		"EMPTY":    {Value: "", Source: SourceUnset},
+		"OVERRIDE": {Value: "file", Source: ".env"},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadEnv() = %#v, want %#v", got, want)
+	}
+}
+
+func TestUndefinedUnused(t *testing.T) {
+	toLoad, err := ParseTemplateVars(strings.NewReader(`
+image: ${IMAGE}:${TAG:-latest}
+environment:
+  MODE: ${MODE:?}
+  DEBUG: ${DEBUG:+--verbose}
+  EMPTY: ${EMPTY:-}
+  OPTIONAL: ${OPTIONAL-}
+  TYPO: $DATABSE_URL
+  UNSET: $UNSET_WITH_DEFAULT
+  SET: ${UNSET_WITH_DEFAULT-default}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defined := map[string]string{"IMAGE": "nginx", "MODE": "prod", "DATABASE_URL": "postgres://db"}
+
+	loaded, err := LoadEnv(toLoad, Source{Name: ".env", Load: LoadKV(defined)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := Undefined(toLoad, loaded), []string{"DATABSE_URL"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Undefined() = %v, want %v", got, want)
+	}
+
+	if got, want := Unused(toLoad, defined), []string{"DATABASE_URL"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Unused() = %v, want %v", got, want)
+	}
+}
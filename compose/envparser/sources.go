@@ -0,0 +1,67 @@
+package envparser
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// LoadKeyring returns a loader function that loads variables from the OS keyring.
+// variables are stored in service under the key env:NAME, or env:SCOPE:NAME with a scope
+func LoadKeyring(service string, scope string) EnvLoader {
+	return func(key string) *string {
+		name := "env:" + key
+		if scope != "" {
+			name = "env:" + scope + ":" + key
+		}
+
+		v, err := keyring.Get(service, name)
+		if err != nil {
+			return nil
+		}
+
+		return &v
+	}
+}
+
+// LoadCommand runs a command and returns a loader function for the .env formatted output.
+// the command may prompt on the terminal, for example for a passphrase
+func LoadCommand(name string, args ...string) (EnvLoader, error) {
+	var stdout bytes.Buffer
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running %s: %w", strings.Join(append([]string{name}, args...), " "), err)
+	}
+
+	// values from secret stores are not expanded with the process environment
+	env, err := ParseEnvFile(&stdout, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing output of %s: %w", name, err)
+	}
+
+	return LoadKV(env), nil
+}
+
+// LoadPass loads a .env formatted entry of the pass password store
+func LoadPass(entry string) (EnvLoader, error) {
+	return LoadCommand("pass", "show", entry)
+}
+
+// LoadSops decrypts a sops encrypted .env file
+func LoadSops(file string) (EnvLoader, error) {
+	return LoadCommand("sops", "--decrypt", "--input-type", "dotenv", "--output-type", "dotenv", file)
+}
+
+// LoadExec runs a shell command printing .env formatted variables
+func LoadExec(command string) (EnvLoader, error) {
+	return LoadCommand("sh", "-c", command)
+}
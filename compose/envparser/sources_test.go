@@ -0,0 +1,68 @@
+package envparser
+
+import (
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestLoadKeyring(t *testing.T) {
+	keyring.MockInit()
+
+	cpy := func(v string) *string {
+		return &v
+	}
+
+	keyring.Set("test", "env:FOO", "foo")
+	keyring.Set("test", "env:prod:FOO", "prod foo")
+
+	tests := []struct {
+		name  string
+		scope string
+		key   string
+		want  *string
+	}{
+		{name: "unscoped", key: "FOO", want: cpy("foo")},
+		{name: "scoped", scope: "prod", key: "FOO", want: cpy("prod foo")},
+		{name: "missing", key: "BAR"},
+		{name: "missing in scope", scope: "dev", key: "FOO"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := LoadKeyring("test", tt.scope)(tt.key)
+
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("LoadKeyring() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadExec(t *testing.T) {
+	loader, err := LoadExec(`printf 'export FOO=bar\nSECRET="s3cr3t $HOME"\n'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := loader("FOO"); got == nil || *got != "bar" {
+		t.Errorf("FOO = %v, want bar", got)
+	}
+
+	// variables are not expanded with the process environment
+	if got := loader("SECRET"); got == nil || *got != "s3cr3t " {
+		t.Errorf("SECRET = %v, want %q", got, "s3cr3t ")
+	}
+
+	if got := loader("MISSING"); got != nil {
+		t.Errorf("MISSING = %v, want nil", *got)
+	}
+
+	if _, err := LoadExec("exit 1"); err == nil {
+		t.Errorf("expected an error for a failing command")
+	}
+
+	if _, err := LoadExec("echo not an env file"); err == nil {
+		t.Errorf("expected an error for invalid output")
+	}
+}
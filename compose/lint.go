@@ -0,0 +1,581 @@
+package compose
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kraudcloud/cli/compose/envparser"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is a problem found in a compose file
+type Issue struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (i Issue) String() string {
+	var b strings.Builder
+
+	if i.Line > 0 {
+		fmt.Fprintf(&b, "%d:", i.Line)
+		if i.Column > 0 {
+			fmt.Fprintf(&b, "%d:", i.Column)
+		}
+		b.WriteString(" ")
+	}
+
+	b.WriteString(i.Severity)
+	b.WriteString(": ")
+
+	if i.Path != "" {
+		b.WriteString(i.Path)
+		b.WriteString(": ")
+	}
+
+	b.WriteString(i.Message)
+	return b.String()
+}
+
+// HasErrors reports whether any of the issues is an error
+func HasErrors(issues []Issue) bool {
+	for _, i := range issues {
+		if i.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Document is a parsed compose file that remembers where things are in the source
+type Document struct {
+	File *File
+	Root *yaml.Node
+
+	// origins are the names of the files the nodes of a merged document come from
+	origins map[*yaml.Node]string
+}
+
+// fileOf returns the name of the file n comes from, if the document is merged from named files
+func (d *Document) fileOf(n *yaml.Node) string {
+	return d.origins[n]
+}
+
+// Node returns the node at path, where path elements are mapping keys or sequence indices
+func (d *Document) Node(path ...string) *yaml.Node {
+	if d.Root == nil {
+		return nil
+	}
+
+	n := d.Root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+
+	for _, p := range path {
+		n = resolve(n)
+
+		switch n.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == p {
+					next = n.Content[i+1]
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			n = next
+
+		case yaml.SequenceNode:
+			idx, err := strconv.Atoi(p)
+			if err != nil {
+				// list syntax of a mapping, such as networks: [front, back]
+				idx = -1
+				for i, item := range n.Content {
+					if resolve(item).Value == p {
+						idx = i
+					}
+				}
+			}
+			if idx < 0 || idx >= len(n.Content) {
+				return nil
+			}
+			n = n.Content[idx]
+
+		default:
+			return nil
+		}
+	}
+
+	return n
+}
+
+// Issue creates an issue positioned at path
+func (d *Document) Issue(severity string, message string, path ...string) Issue {
+	issue := Issue{
+		Path:     issuePath(path),
+		Severity: severity,
+		Message:  message,
+	}
+
+	// point at the key rather than its value where possible
+	if len(path) > 0 {
+		if parent := d.Node(path[:len(path)-1]...); parent != nil && resolve(parent).Kind == yaml.MappingNode {
+			parent = resolve(parent)
+			for i := 0; i+1 < len(parent.Content); i += 2 {
+				if parent.Content[i].Value == path[len(path)-1] {
+					key := parent.Content[i]
+					issue.File, issue.Line, issue.Column = d.fileOf(key), key.Line, key.Column
+					return issue
+				}
+			}
+		}
+	}
+
+	if n := d.Node(path...); n != nil {
+		issue.File, issue.Line, issue.Column = d.fileOf(n), n.Line, n.Column
+	}
+
+	return issue
+}
+
+func issuePath(path []string) string {
+	var b strings.Builder
+	for i, p := range path {
+		if _, err := strconv.Atoi(p); err == nil && i > 0 {
+			b.WriteString("[" + p + "]")
+			continue
+		}
+
+		if i > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+var errorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// errorIssues converts yaml errors, which only carry a line number in their message, to issues
+func errorIssues(err error, path ...string) []Issue {
+	var messages []string
+
+	var terr *yaml.TypeError
+	if errors.As(err, &terr) {
+		messages = terr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	var issues []Issue
+	for _, msg := range messages {
+		issue := Issue{Path: issuePath(path), Severity: SeverityError, Message: msg}
+
+		if m := errorLine.FindStringSubmatch(msg); m != nil {
+			issue.Line, _ = strconv.Atoi(m[1])
+			issue.Message = m[2]
+		}
+
+		issues = append(issues, issue)
+	}
+
+	return issues
+}
+
+// Lint parses a compose file and checks it against the compose specification.
+// variables are substituted with env first, like launching the file would, without moving any positions.
+// with a nil env only variables with a default are substituted, and values with other variables are not type checked.
+// the returned document is nil if the file is not valid yaml
+func Lint(data []byte, env map[string]string) (*Document, []Issue) {
+	var root yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&root); err != nil {
+		return nil, errorIssues(err)
+	}
+
+	doc := &Document{Root: &root, File: &File{}}
+	if top := doc.Node(); top == nil || top.Kind != yaml.MappingNode {
+		return nil, []Issue{doc.Issue(SeverityError, "a compose file must be a mapping")}
+	}
+
+	issues := doc.lint(env)
+	SortIssues(issues)
+	return doc, issues
+}
+
+// lint interpolates, type checks and checks a single document
+func (d *Document) lint(env map[string]string) []Issue {
+	issues, uninterpolated := d.interpolate(env)
+	issues = append(issues, d.decode(uninterpolated)...)
+	return append(issues, d.check()...)
+}
+
+// LintFiles merges compose files like Merge and checks them like Lint.
+// issues point at the file and position the merged value comes from
+func LintFiles(names []string, files [][]byte, env map[string]string) (*Document, []Issue) {
+	m := &merger{origins: map[*yaml.Node]string{}}
+
+	var docs []*yaml.Node
+	var issues []Issue
+	for i, data := range files {
+		var root yaml.Node
+		if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&root); err != nil {
+			for _, issue := range errorIssues(err) {
+				issue.File = names[i]
+				issues = append(issues, issue)
+			}
+			continue
+		}
+
+		doc := &Document{Root: &root, File: &File{}, origins: m.origins}
+		if top := doc.Node(); top == nil || top.Kind != yaml.MappingNode {
+			issues = append(issues, Issue{File: names[i], Severity: SeverityError, Message: "a compose file must be a mapping"})
+			continue
+		}
+		m.trackFile(&root, names[i])
+
+		// yaml errors only carry a line, so values are type checked in the file they are in
+		interpolateIssues, uninterpolated := doc.interpolate(env)
+		issues = append(issues, interpolateIssues...)
+		for _, issue := range doc.decode(uninterpolated) {
+			issue.File = names[i]
+			issues = append(issues, issue)
+		}
+
+		docs = append(docs, &root)
+	}
+
+	if len(docs) < len(files) {
+		SortIssues(issues)
+		return nil, issues
+	}
+
+	merged, err := m.mergeDocs(docs)
+	if err != nil {
+		return nil, []Issue{{Severity: SeverityError, Message: err.Error()}}
+	}
+
+	doc := &Document{Root: &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{merged}}, File: &File{}, origins: m.origins}
+
+	// errors are reported for the files already
+	doc.decode(nil)
+	issues = append(issues, doc.check()...)
+
+	SortIssues(issues)
+	return doc, issues
+}
+
+// interpolate substitutes variables in the document in place.
+// it returns issues for values that can't be interpolated with env, and the lines of all of them
+func (d *Document) interpolate(env map[string]string) ([]Issue, map[int]bool) {
+	var issues []Issue
+	uninterpolated := map[int]bool{}
+
+	for _, n := range interpolateNodes(d.Node(), env) {
+		uninterpolated[n.node.Line] = true
+		if env != nil {
+			issues = append(issues, Issue{File: d.fileOf(n.node), Line: n.node.Line, Column: n.node.Column, Severity: SeverityError, Message: n.err.Error()})
+		}
+	}
+
+	return issues, uninterpolated
+}
+
+// decode decodes the document into d.File and returns the type errors, except on the skipped lines
+func (d *Document) decode(skip map[int]bool) []Issue {
+	var issues []Issue
+
+	// a value that still contains a variable can't be checked for its type
+	errorIssues := func(err error, path ...string) []Issue {
+		var kept []Issue
+		for _, issue := range errorIssues(err, path...) {
+			if !skip[issue.Line] {
+				kept = append(kept, issue)
+			}
+		}
+		return kept
+	}
+
+	// decode each service on its own, so that one broken service doesn't hide the others
+	top := d.Node()
+	rest := &yaml.Node{Kind: yaml.MappingNode}
+	err := mapping(top, func(key string, value *yaml.Node) error {
+		if key != "services" {
+			rest.Content = append(rest.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+			return nil
+		}
+
+		d.File.Services = map[string]Service{}
+		return mapping(value, func(name string, value *yaml.Node) error {
+			var s Service
+			if err := value.Decode(&s); err != nil {
+				issues = append(issues, errorIssues(err, "services", name)...)
+			}
+			d.File.Services[name] = s
+			return nil
+		})
+	})
+	if err != nil {
+		issues = append(issues, errorIssues(err)...)
+	}
+
+	services := d.File.Services
+	if err := rest.Decode(d.File); err != nil {
+		issues = append(issues, errorIssues(err)...)
+	}
+	d.File.Services = services
+
+	return issues
+}
+
+// check reports unknown keys and references to things that are not declared
+func (d *Document) check() []Issue {
+	issues := d.checkKeys(d.Node(), reflect.TypeOf(File{}), nil)
+	return append(issues, d.checkReferences()...)
+}
+
+type interpolateError struct {
+	node *yaml.Node
+	err  error
+}
+
+// interpolateNodes substitutes variables in the scalar values below n in place.
+// their tags are resolved again, so that ${REPLICAS:-2} is a number like 2 would be.
+// values that can't be interpolated are left as they are
+func interpolateNodes(n *yaml.Node, env map[string]string) []interpolateError {
+	var failed []interpolateError
+
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "$") {
+			return nil
+		}
+
+		// without an env, only values whose variables all have a default are known
+		if env == nil {
+			vars, err := envparser.ParseTemplateVars(strings.NewReader(n.Value))
+			if err != nil {
+				return []interpolateError{{node: n, err: err}}
+			}
+			for _, v := range vars {
+				if v.Default == "" {
+					return []interpolateError{{node: n, err: fmt.Errorf("variable %s has no default", v.Name)}}
+				}
+			}
+		}
+
+		out, err := envparser.Interpolate([]byte(n.Value), env)
+		if err != nil {
+			return []interpolateError{{node: n, err: err}}
+		}
+
+		n.Value = string(out)
+		n.Tag = ""
+		n.Style = 0
+	case yaml.MappingNode:
+		// keys are not interpolated
+		for i := 1; i < len(n.Content); i += 2 {
+			failed = append(failed, interpolateNodes(n.Content[i], env)...)
+		}
+	case yaml.SequenceNode, yaml.DocumentNode:
+		for _, c := range n.Content {
+			failed = append(failed, interpolateNodes(c, env)...)
+		}
+	}
+
+	return failed
+}
+
+// SortIssues sorts issues by their file and position in the file
+func SortIssues(issues []Issue) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		if issues[i].Line != issues[j].Line {
+			return issues[i].Line < issues[j].Line
+		}
+		return issues[i].Column < issues[j].Column
+	})
+}
+
+// knownKeys are keys of the compose specification that are valid but not modelled
+var knownKeys = map[reflect.Type][]string{
+	reflect.TypeOf(File{}): {"include"},
+	reflect.TypeOf(Service{}): {
+		"annotations", "attach", "blkio_config", "cgroup", "cgroup_parent", "cpu_count", "cpu_percent",
+		"cpu_period", "cpu_quota", "cpu_rt_period", "cpu_rt_runtime", "cpu_shares", "cpus", "cpuset",
+		"credential_spec", "develop", "device_cgroup_rules", "devices", "dns", "dns_opt", "dns_search",
+		"domainname", "extends", "external_links", "extra_hosts", "group_add", "ipc", "isolation", "links",
+		"logging", "mac_address", "mem_limit", "mem_reservation", "mem_swappiness", "memswap_limit",
+		"network_mode", "oom_kill_disable", "oom_score_adj", "pid", "pids_limit", "runtime", "scale",
+		"security_opt", "shm_size", "storage_opt", "sysctls", "tmpfs", "ulimits", "userns_mode", "uts",
+		"volumes_from",
+	},
+	reflect.TypeOf(BuildConfig{}): {
+		"additional_contexts", "extra_hosts", "isolation", "no_cache", "privileged", "pull", "secrets",
+		"ssh", "tags", "ulimits",
+	},
+	reflect.TypeOf(Deploy{}):   {"endpoint_mode", "placement", "rollback_config", "update_config"},
+	reflect.TypeOf(Resource{}): {"devices", "generic_resources"},
+	reflect.TypeOf(Network{}):  {"enable_ipv6", "ipam"},
+}
+
+// checkKeys reports mapping keys that are not part of the compose specification
+func (d *Document) checkKeys(n *yaml.Node, t reflect.Type, path []string) []Issue {
+	n = resolve(n)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var issues []Issue
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name != "" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+
+		for _, k := range knownKeys[t] {
+			fields[k] = nil
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+
+			if key.ShortTag() == "!!merge" {
+				issues = append(issues, d.checkKeys(n.Content[i+1], t, path)...)
+				continue
+			}
+
+			if strings.HasPrefix(key.Value, "x-") {
+				continue
+			}
+
+			ft, ok := fields[key.Value]
+			if !ok {
+				issues = append(issues, Issue{
+					File:     d.fileOf(key),
+					Line:     key.Line,
+					Column:   key.Column,
+					Path:     issuePath(append(path, key.Value)),
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("unknown key %q", key.Value),
+				})
+				continue
+			}
+
+			if ft != nil {
+				issues = append(issues, d.checkKeys(n.Content[i+1], ft, append(path, key.Value))...)
+			}
+		}
+
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			issues = append(issues, d.checkKeys(n.Content[i+1], t.Elem(), append(path, n.Content[i].Value))...)
+		}
+
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return nil
+		}
+
+		for i, item := range n.Content {
+			issues = append(issues, d.checkKeys(item, t.Elem(), append(path, strconv.Itoa(i)))...)
+		}
+	}
+
+	return issues
+}
+
+// checkReferences reports services, volumes, networks, secrets and configs that are used but not declared
+func (d *Document) checkReferences() []Issue {
+	var issues []Issue
+	f := d.File
+
+	if len(f.Services) == 0 {
+		issues = append(issues, d.Issue(SeverityError, "no services defined"))
+	}
+
+	for _, name := range sortedKeys(f.Services) {
+		s := f.Services[name]
+
+		if s.Image == "" && s.Build == nil {
+			issues = append(issues, d.Issue(SeverityError, "service has neither an image nor a build", "services", name))
+		}
+
+		for dep := range s.DependsOn {
+			if _, ok := f.Services[dep]; !ok {
+				issues = append(issues, d.Issue(SeverityError, fmt.Sprintf("depends on undefined service %q", dep), "services", name, "depends_on"))
+			}
+		}
+
+		for i, v := range s.Volumes {
+			if v.Type != VolumeTypeVolume || v.Source == "" {
+				continue
+			}
+
+			if _, ok := f.Volumes[v.Source]; !ok {
+				issues = append(issues, d.Issue(SeverityError, fmt.Sprintf("volume %q is not declared in the top-level volumes", v.Source), "services", name, "volumes", strconv.Itoa(i)))
+			}
+		}
+
+		for network := range s.Networks {
+			if _, ok := f.Networks[network]; !ok && network != "default" {
+				issues = append(issues, d.Issue(SeverityError, fmt.Sprintf("network %q is not declared in the top-level networks", network), "services", name, "networks", network))
+			}
+		}
+
+		for i, secret := range s.Secrets {
+			if _, ok := f.Secrets[secret.Source]; !ok {
+				issues = append(issues, d.Issue(SeverityError, fmt.Sprintf("secret %q is not declared in the top-level secrets", secret.Source), "services", name, "secrets", strconv.Itoa(i)))
+			}
+		}
+
+		for i, config := range s.Configs {
+			if _, ok := f.Configs[config.Source]; !ok {
+				issues = append(issues, d.Issue(SeverityError, fmt.Sprintf("config %q is not declared in the top-level configs", config.Source), "services", name, "configs", strconv.Itoa(i)))
+			}
+		}
+	}
+
+	return issues
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,165 @@
+package compose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		env  map[string]string
+		out  []Issue
+	}{
+		{
+			name: "valid",
+			in: `
+services:
+  web:
+    image: nginx
+    volumes: [data:/data]
+    networks: [front]
+    x-custom: true
+volumes:
+  data:
+networks:
+  front:
+`,
+		},
+		{
+			name: "syntax",
+			in:   "services:\n  web:\n\timage: nginx\n",
+			out:  []Issue{{Line: 3, Severity: SeverityError, Message: "found character that cannot start any token"}},
+		},
+		{
+			name: "no services",
+			in:   "version: '3'\n",
+			out:  []Issue{{Line: 1, Column: 1, Severity: SeverityError, Message: "no services defined"}},
+		},
+		{
+			name: "unknown keys",
+			in: `
+services:
+  web:
+    image: nginx
+    imgae: nginx
+    healthcheck:
+      tset: [CMD, "true"]
+`,
+			out: []Issue{
+				{Line: 5, Column: 5, Path: "services.web.imgae", Severity: SeverityError, Message: `unknown key "imgae"`},
+				{Line: 7, Column: 7, Path: "services.web.healthcheck.tset", Severity: SeverityError, Message: `unknown key "tset"`},
+			},
+		},
+		{
+			name: "type errors",
+			in: `
+services:
+  web:
+    image: nginx
+    ports: ["80:http"]
+    privileged: maybe
+`,
+			out: []Issue{
+				{Line: 5, Path: "services.web", Severity: SeverityError, Message: `invalid port "80:http": strconv.ParseUint: parsing "http": invalid syntax`},
+				{Line: 6, Path: "services.web", Severity: SeverityError, Message: "cannot unmarshal !!str `maybe` into bool"},
+			},
+		},
+		{
+			name: "templated scalars",
+			in: `
+services:
+  web:
+    image: nginx:${TAG:-latest}
+    privileged: ${PRIVILEGED:-false}
+    ports: ["8080:${PORT:-80}", "${RANGE}"]
+    deploy:
+      replicas: ${REPLICAS:-2}
+`,
+		},
+		{
+			name: "templated scalars with env",
+			env:  map[string]string{"REPLICAS": "many", "PORT": "80"},
+			in: `
+services:
+  web:
+    image: nginx
+    ports: ["8080:${PORT}"]
+    deploy:
+      replicas: ${REPLICAS:-2}
+      labels:
+        team: ${TEAM:?the team is required}
+`,
+			out: []Issue{
+				{Line: 7, Path: "services.web", Severity: SeverityError, Message: "cannot unmarshal !!str `many` into uint64"},
+				{Line: 9, Column: 15, Severity: SeverityError, Message: "required variable TEAM is missing a value: the team is required"},
+			},
+		},
+		{
+			name: "undeclared references",
+			in: `
+services:
+  web:
+    image: nginx
+    depends_on: [db]
+    volumes:
+      - ./html:/html
+      - data:/data
+    networks:
+      front:
+    secrets: [key]
+  worker:
+    command: work
+`,
+			out: []Issue{
+				{Line: 5, Column: 5, Path: "services.web.depends_on", Severity: SeverityError, Message: `depends on undefined service "db"`},
+				{Line: 8, Column: 9, Path: "services.web.volumes[1]", Severity: SeverityError, Message: `volume "data" is not declared in the top-level volumes`},
+				{Line: 10, Column: 7, Path: "services.web.networks.front", Severity: SeverityError, Message: `network "front" is not declared in the top-level networks`},
+				{Line: 11, Column: 15, Path: "services.web.secrets[0]", Severity: SeverityError, Message: `secret "key" is not declared in the top-level secrets`},
+				{Line: 12, Column: 3, Path: "services.worker", Severity: SeverityError, Message: "service has neither an image nor a build"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := Lint([]byte(tt.in), tt.env)
+			if !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("Lint() =\n%+v\nwant\n%+v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestLintFiles(t *testing.T) {
+	base := `services:
+  web:
+    image: nginx
+    volumes: [data:/data]
+    privileged: ${PRIVILEGED:-false}
+`
+	override := `services:
+  web:
+    imgae: nginx
+    ports: ["80:http"]
+  worker:
+    command: work
+    deploy:
+      replicas: ${REPLICAS}
+`
+
+	_, got := LintFiles([]string{"compose.yml", "compose.override.yml"}, [][]byte{[]byte(base), []byte(override)}, map[string]string{"REPLICAS": "two"})
+
+	want := []Issue{
+		{File: "compose.override.yml", Line: 3, Column: 5, Path: "services.web.imgae", Severity: SeverityError, Message: `unknown key "imgae"`},
+		{File: "compose.override.yml", Line: 4, Path: "services.web", Severity: SeverityError, Message: `invalid port "80:http": strconv.ParseUint: parsing "http": invalid syntax`},
+		{File: "compose.override.yml", Line: 5, Column: 3, Path: "services.worker", Severity: SeverityError, Message: "service has neither an image nor a build"},
+		{File: "compose.override.yml", Line: 8, Path: "services.worker", Severity: SeverityError, Message: "cannot unmarshal !!str `two` into uint64"},
+		{File: "compose.yml", Line: 4, Column: 15, Path: "services.web.volumes[0]", Severity: SeverityError, Message: `volume "data" is not declared in the top-level volumes`},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LintFiles() =\n%+v\nwant\n%+v", got, want)
+	}
+}
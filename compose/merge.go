@@ -0,0 +1,459 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Merge merges compose files in order, later files override earlier ones,
+// following the merge rules of the compose specification:
+//
+//   - mappings are merged recursively and scalars are replaced
+//   - command, entrypoint and healthcheck tests are replaced
+//   - environment, labels, build args, depends_on and networks are merged as mappings,
+//     regardless of whether they use the list or mapping syntax
+//   - ports, volumes, secrets, configs and env_file replace entries with the same target and append the rest
+//   - other sequences are appended, without duplicates
+//   - the !reset tag removes a value and the !override tag replaces it without merging
+//
+// merging happens before interpolation, so variables in the result are left as they are
+func Merge(files ...[]byte) ([]byte, error) {
+	if len(files) == 1 {
+		return files[0], nil
+	}
+
+	var docs []*yaml.Node
+	for i, data := range files {
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("file %d: %w", i+1, err)
+		}
+		docs = append(docs, &doc)
+	}
+
+	merged, err := (&merger{}).mergeDocs(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(merged); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// merger merges parsed compose files.
+// with origins set, it also remembers the file every node of the result comes from
+type merger struct {
+	origins map[*yaml.Node]string
+}
+
+// track records that n comes from the same file as from
+func (m *merger) track(n, from *yaml.Node) *yaml.Node {
+	if m.origins != nil {
+		if name, ok := m.origins[from]; ok {
+			m.origins[n] = name
+		}
+	}
+	return n
+}
+
+// trackFile records that n and all nodes below it come from the file name
+func (m *merger) trackFile(n *yaml.Node, name string) {
+	if _, ok := m.origins[n]; ok {
+		return
+	}
+
+	m.origins[n] = name
+	if n.Alias != nil {
+		m.trackFile(n.Alias, name)
+	}
+	for _, c := range n.Content {
+		m.trackFile(c, name)
+	}
+}
+
+// mergeDocs merges parsed documents in order and returns the merged top level mapping
+func (m *merger) mergeDocs(docs []*yaml.Node) (*yaml.Node, error) {
+	var merged *yaml.Node
+	for i, doc := range docs {
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("file %d: a compose file must be a mapping", i+1)
+		}
+
+		merged = m.mergeNode(merged, root, nil)
+	}
+
+	if merged == nil {
+		return nil, fmt.Errorf("no compose files to merge")
+	}
+
+	return merged, nil
+}
+
+const (
+	tagReset    = "!reset"
+	tagOverride = "!override"
+)
+
+// mergeRule is how sequences at a path are merged
+type mergeRule int
+
+const (
+	mergeAppend mergeRule = iota
+	mergeReplace
+	mergeMapping
+	mergeKeyed
+)
+
+// mergeRules are keyed by the path below a service, or the full path outside of services
+var mergeRules = map[string]mergeRule{
+	"command":          mergeReplace,
+	"entrypoint":       mergeReplace,
+	"healthcheck.test": mergeReplace,
+
+	"environment":   mergeMapping,
+	"labels":        mergeMapping,
+	"annotations":   mergeMapping,
+	"build.args":    mergeMapping,
+	"build.labels":  mergeMapping,
+	"depends_on":    mergeMapping,
+	"networks":      mergeMapping,
+	"extra_hosts":   mergeMapping,
+	"sysctls":       mergeMapping,
+	"deploy.labels": mergeMapping,
+
+	"ports":    mergeKeyed,
+	"volumes":  mergeKeyed,
+	"secrets":  mergeKeyed,
+	"configs":  mergeKeyed,
+	"env_file": mergeKeyed,
+}
+
+func ruleFor(path []string) mergeRule {
+	if len(path) > 2 && path[0] == "services" {
+		return mergeRules[strings.Join(path[2:], ".")]
+	}
+
+	return mergeAppend
+}
+
+func (m *merger) mergeNode(base, over *yaml.Node, path []string) *yaml.Node {
+	over = resolve(over)
+
+	if over.Tag == tagOverride {
+		over = m.copyNode(over)
+		over.Tag = ""
+		return over
+	}
+
+	if base == nil {
+		return m.copyNode(over)
+	}
+	base = resolve(base)
+
+	rule := ruleFor(path)
+
+	switch {
+	case rule == mergeReplace:
+		return m.copyNode(over)
+
+	case rule == mergeMapping && (base.Kind != yaml.ScalarNode && over.Kind != yaml.ScalarNode):
+		return m.mergeMappings(m.toMapping(base, path), m.toMapping(over, path), path)
+
+	case base.Kind == yaml.MappingNode && over.Kind == yaml.MappingNode:
+		return m.mergeMappings(base, over, path)
+
+	case base.Kind == yaml.SequenceNode && over.Kind == yaml.SequenceNode:
+		if rule == mergeKeyed {
+			return m.mergeKeyedSequences(base, over, path)
+		}
+		return m.mergeSequences(base, over)
+
+	default:
+		return m.copyNode(over)
+	}
+}
+
+func (m *merger) mergeMappings(base, over *yaml.Node, path []string) *yaml.Node {
+	out := m.track(&yaml.Node{Kind: yaml.MappingNode, Style: base.Style, HeadComment: base.HeadComment, Line: base.Line, Column: base.Column}, base)
+	index := map[string]int{}
+
+	for _, pair := range mappingPairs(base) {
+		index[pair[0].Value] = len(out.Content)
+		out.Content = append(out.Content, pair[0], pair[1])
+	}
+
+	for _, pair := range mappingPairs(over) {
+		key, value := pair[0], pair[1]
+
+		i, exists := index[key.Value]
+		if resolve(value).Tag == tagReset {
+			if exists {
+				out.Content[i] = nil
+				out.Content[i+1] = nil
+				delete(index, key.Value)
+			}
+			continue
+		}
+
+		if !exists {
+			index[key.Value] = len(out.Content)
+			out.Content = append(out.Content, key, m.mergeNode(nil, value, append(path, key.Value)))
+			continue
+		}
+
+		out.Content[i+1] = m.mergeNode(out.Content[i+1], value, append(path, key.Value))
+	}
+
+	// drop reset keys
+	content := out.Content[:0]
+	for _, n := range out.Content {
+		if n != nil {
+			content = append(content, n)
+		}
+	}
+	out.Content = content
+
+	return out
+}
+
+// mappingPairs returns the key value pairs of a mapping, with merge keys (<<) applied
+func mappingPairs(n *yaml.Node) [][2]*yaml.Node {
+	n = resolve(n)
+
+	var pairs [][2]*yaml.Node
+	index := map[string]int{}
+
+	add := func(key, value *yaml.Node) {
+		if i, ok := index[key.Value]; ok {
+			pairs[i][1] = value
+			return
+		}
+
+		index[key.Value] = len(pairs)
+		pairs = append(pairs, [2]*yaml.Node{key, value})
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].ShortTag() != "!!merge" {
+			continue
+		}
+
+		merge := resolve(n.Content[i+1])
+		if merge.Kind != yaml.SequenceNode {
+			merge = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{merge}}
+		}
+
+		for _, m := range merge.Content {
+			for _, pair := range mappingPairs(m) {
+				add(pair[0], pair[1])
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].ShortTag() != "!!merge" {
+			add(n.Content[i], n.Content[i+1])
+		}
+	}
+
+	return pairs
+}
+
+// toMapping converts the list syntax of environment, labels, depends_on and networks to a mapping
+func (m *merger) toMapping(n *yaml.Node, path []string) *yaml.Node {
+	if n.Kind != yaml.SequenceNode {
+		return n
+	}
+
+	out := m.track(&yaml.Node{Kind: yaml.MappingNode, Line: n.Line, Column: n.Column}, n)
+	isDependsOn := path[len(path)-1] == "depends_on"
+	isNetworks := path[len(path)-1] == "networks"
+
+	for _, item := range n.Content {
+		item = resolve(item)
+
+		var key string
+		var value *yaml.Node
+
+		switch {
+		case isDependsOn:
+			key = item.Value
+			value = &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "condition"},
+				{Kind: yaml.ScalarNode, Value: ConditionServiceStarted},
+			}}
+		case isNetworks:
+			key = item.Value
+			value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+		default:
+			k, v, ok := strings.Cut(item.Value, "=")
+			if path[len(path)-1] == "extra_hosts" && !ok {
+				k, v, ok = strings.Cut(item.Value, ":")
+			}
+
+			key = k
+			if ok {
+				value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+			} else {
+				value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+			}
+		}
+
+		value.Line, value.Column = item.Line, item.Column
+		out.Content = append(out.Content, m.track(&yaml.Node{Kind: yaml.ScalarNode, Value: key, Line: item.Line, Column: item.Column}, item), m.track(value, item))
+	}
+
+	return out
+}
+
+func (m *merger) mergeSequences(base, over *yaml.Node) *yaml.Node {
+	out := m.copyNode(base)
+
+	seen := map[string]bool{}
+	for _, item := range out.Content {
+		if item := resolve(item); item.Kind == yaml.ScalarNode {
+			seen[item.Value] = true
+		}
+	}
+
+	for _, item := range over.Content {
+		if item := resolve(item); item.Kind == yaml.ScalarNode {
+			if seen[item.Value] {
+				continue
+			}
+			seen[item.Value] = true
+		}
+
+		out.Content = append(out.Content, m.copyNode(item))
+	}
+
+	return blockIfNested(out)
+}
+
+// mergeKeyedSequences replaces entries that refer to the same target and appends the rest
+func (m *merger) mergeKeyedSequences(base, over *yaml.Node, path []string) *yaml.Node {
+	out := m.copyNode(base)
+	field := path[len(path)-1]
+
+	index := map[string]int{}
+	for i, item := range out.Content {
+		index[sequenceKey(field, item)] = i
+	}
+
+	for _, item := range over.Content {
+		key := sequenceKey(field, item)
+		if i, ok := index[key]; ok {
+			out.Content[i] = m.copyNode(item)
+			continue
+		}
+
+		index[key] = len(out.Content)
+		out.Content = append(out.Content, m.copyNode(item))
+	}
+
+	return blockIfNested(out)
+}
+
+// blockIfNested switches a flow sequence to block style if an override added mappings to it
+func blockIfNested(n *yaml.Node) *yaml.Node {
+	for _, item := range n.Content {
+		if item.Kind != yaml.ScalarNode {
+			n.Style &^= yaml.FlowStyle
+			break
+		}
+	}
+	return n
+}
+
+// sequenceKey identifies what an entry of ports, volumes, secrets, configs and env_file refers to
+func sequenceKey(field string, item *yaml.Node) string {
+	item = resolve(item)
+
+	if item.Kind == yaml.ScalarNode {
+		switch field {
+		case "volumes":
+			if v, err := ParseVolume(item.Value); err == nil {
+				return v.Target
+			}
+		case "ports":
+			if p, err := ParsePort(item.Value); err == nil {
+				return fmt.Sprintf("%s:%s:%s/%s", p.HostIP, p.Published, p.Target, protocolOrTCP(p.Protocol))
+			}
+		}
+		return item.Value
+	}
+
+	switch field {
+	case "volumes":
+		var v ServiceVolume
+		if item.Decode(&v) == nil {
+			return v.Target
+		}
+	case "ports":
+		var p Port
+		if item.Decode(&p) == nil {
+			return fmt.Sprintf("%s:%s:%s/%s", p.HostIP, p.Published, p.Target, protocolOrTCP(p.Protocol))
+		}
+	case "secrets", "configs":
+		var ref ServiceConfigRef
+		if item.Decode(&ref) == nil {
+			return ref.Source
+		}
+	case "env_file":
+		var e EnvFile
+		if item.Decode(&e) == nil {
+			return e.Path
+		}
+	}
+
+	var buf bytes.Buffer
+	yaml.NewEncoder(&buf).Encode(item)
+	return buf.String()
+}
+
+func protocolOrTCP(protocol string) string {
+	if protocol == "" {
+		return "tcp"
+	}
+	return protocol
+}
+
+// copyNode deep copies a node, resolving aliases and merge keys, since anchors don't survive merging
+func (m *merger) copyNode(n *yaml.Node) *yaml.Node {
+	n = resolve(n)
+
+	out := *n
+	out.Anchor = ""
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		out.Content = nil
+		for _, pair := range mappingPairs(n) {
+			out.Content = append(out.Content, pair[0], m.copyNode(pair[1]))
+		}
+	case yaml.SequenceNode:
+		out.Content = make([]*yaml.Node, len(n.Content))
+		for i, item := range n.Content {
+			out.Content[i] = m.copyNode(item)
+		}
+	}
+
+	return m.track(&out, n)
+}
@@ -0,0 +1,270 @@
+package compose
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		out   string
+		error bool
+	}{
+		{
+			name: "single file is untouched",
+			files: []string{`
+# comment
+services: {web: {image: nginx}}
+`},
+			out: `
+# comment
+services: {web: {image: nginx}}
+`,
+		},
+		{
+			name: "scalars and mappings",
+			files: []string{`
+services:
+  web:
+    image: nginx:1.24
+    restart: always
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost"]
+      interval: 30s
+`, `
+services:
+  web:
+    image: nginx:1.25
+    healthcheck:
+      test: curl -f http://localhost/health
+  worker:
+    image: worker
+`},
+			out: `
+services:
+  web:
+    image: nginx:1.25
+    restart: always
+    healthcheck:
+      test: curl -f http://localhost/health
+      interval: 30s
+  worker:
+    image: worker
+`,
+		},
+		{
+			name: "commands are replaced",
+			files: []string{`
+services:
+  web:
+    command: [nginx, -g, daemon off;]
+    entrypoint: /docker-entrypoint.sh
+`, `
+services:
+  web:
+    command: [nginx-debug]
+`},
+			out: `
+services:
+  web:
+    command: [nginx-debug]
+    entrypoint: /docker-entrypoint.sh
+`,
+		},
+		{
+			name: "list and mapping syntax",
+			files: []string{`
+services:
+  web:
+    environment:
+      - FOO=1
+      - BAR
+    labels:
+      a: b
+    depends_on: [db]
+    networks: [front]
+`, `
+services:
+  web:
+    environment:
+      FOO: 2
+      BAZ: "3"
+    labels:
+      - c=d
+    depends_on:
+      cache:
+        condition: service_healthy
+    networks:
+      back:
+        aliases: [web]
+`},
+			out: `
+services:
+  web:
+    environment:
+      FOO: 2
+      BAR: null
+      BAZ: "3"
+    labels:
+      a: b
+      c: d
+    depends_on:
+      db:
+        condition: service_started
+      cache:
+        condition: service_healthy
+    networks:
+      front: null
+      back:
+        aliases: [web]
+`,
+		},
+		{
+			name: "keyed sequences",
+			files: []string{`
+services:
+  web:
+    ports:
+      - "80:80"
+      - "443:443"
+    volumes:
+      - ./html:/usr/share/nginx/html
+      - logs:/var/log/nginx
+    secrets: [cert]
+`, `
+services:
+  web:
+    ports:
+      - "8080:8080"
+      - target: 443
+        published: "443"
+        mode: host
+    volumes:
+      - type: bind
+        source: ./public
+        target: /usr/share/nginx/html
+    secrets:
+      - source: cert
+        target: /run/secrets/tls.crt
+`},
+			out: `
+services:
+  web:
+    ports:
+      - "80:80"
+      - target: 443
+        published: "443"
+        mode: host
+      - "8080:8080"
+    volumes:
+      - type: bind
+        source: ./public
+        target: /usr/share/nginx/html
+      - logs:/var/log/nginx
+    secrets:
+      - source: cert
+        target: /run/secrets/tls.crt
+`,
+		},
+		{
+			name: "sequences are appended without duplicates",
+			files: []string{`
+services:
+  web:
+    cap_add: [NET_ADMIN]
+    profiles: [web]
+`, `
+services:
+  web:
+    cap_add: [NET_ADMIN, SYS_TIME]
+`},
+			out: `
+services:
+  web:
+    cap_add: [NET_ADMIN, SYS_TIME]
+    profiles: [web]
+`,
+		},
+		{
+			name: "reset and override",
+			files: []string{`
+services:
+  web:
+    image: nginx
+    ports: ["80:80"]
+    environment:
+      FOO: 1
+      BAR: 2
+`, `
+services:
+  web:
+    ports: !reset []
+    environment: !override
+      BAZ: 3
+`},
+			out: `
+services:
+  web:
+    image: nginx
+    environment:
+      BAZ: 3
+`,
+		},
+		{
+			name: "anchors are resolved",
+			files: []string{`
+x-env: &env
+  FOO: 1
+services:
+  web:
+    environment:
+      <<: *env
+      BAR: 2
+`, `
+services:
+  web:
+    environment:
+      BAZ: 3
+`},
+			out: `
+x-env:
+  FOO: 1
+services:
+  web:
+    environment:
+      FOO: 1
+      BAR: 2
+      BAZ: 3
+`,
+		},
+		{
+			name:  "invalid",
+			files: []string{"services: {}", "- foo"},
+			error: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files [][]byte
+			for _, f := range tt.files {
+				files = append(files, []byte(f))
+			}
+
+			got, err := Merge(files...)
+			if (err != nil) != tt.error {
+				t.Fatalf("Merge() error = %v, wantErr %v", err, tt.error)
+			}
+
+			if tt.error {
+				return
+			}
+
+			if strings.TrimSpace(string(got)) != strings.TrimSpace(tt.out) {
+				t.Errorf("Merge() =\n%s\nwant\n%s", got, strings.TrimSpace(tt.out))
+			}
+		})
+	}
+}
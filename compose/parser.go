@@ -1,8 +1,10 @@
 package compose
 
 import (
-	"gopkg.in/yaml.v3"
+	"io"
 	"os"
+
+	"gopkg.in/yaml.v3"
 )
 
 func ParseFile(filename string) (*File, error) {
@@ -10,12 +12,31 @@ func ParseFile(filename string) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
+	return Parse(f)
+}
+
+// Parse decodes a compose file from r
+func Parse(r io.Reader) (*File, error) {
 	var file = &File{}
-	err = yaml.NewDecoder(f).Decode(file)
+	err := yaml.NewDecoder(r).Decode(file)
 	if err != nil {
 		return nil, err
 	}
 
 	return file, nil
 }
+
+// Encode writes a compose file to w in long syntax
+func Encode(w io.Writer, file *File) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	err := enc.Encode(file)
+	if err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
@@ -0,0 +1,171 @@
+package compose
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Deployed is the current state of a deployed service, as far as a plan compares it.
+// empty fields are unknown and not compared
+type Deployed struct {
+	Name   string
+	Image  string
+	Env    map[string]string
+	CPU    string
+	Memory string
+}
+
+type Action string
+
+const (
+	ActionCreate  Action = "create"
+	ActionReplace Action = "replace"
+	ActionRemove  Action = "remove"
+)
+
+// Change is what launching a compose file would do to a service
+type Change struct {
+	Service string   `json:"service"`
+	Action  Action   `json:"action"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// Plan compares the selected services against what is deployed.
+// services that are unchanged are left out, deployments that are not defined
+// in the file at all are removed. values of environment variables are not
+// included in the reasons, since they may be secret
+func (f *File) Plan(services []string, deployed []Deployed) []Change {
+	var changes []Change
+
+	current := map[string]Deployed{}
+	for _, d := range deployed {
+		current[d.Name] = d
+	}
+
+	for _, name := range services {
+		s, ok := f.Services[name]
+		if !ok {
+			continue
+		}
+
+		d, ok := current[name]
+		if !ok {
+			changes = append(changes, Change{Service: name, Action: ActionCreate})
+			continue
+		}
+
+		if reasons := s.diff(d); len(reasons) > 0 {
+			changes = append(changes, Change{Service: name, Action: ActionReplace, Reasons: reasons})
+		}
+	}
+
+	for _, d := range deployed {
+		if _, ok := f.Services[d.Name]; !ok {
+			changes = append(changes, Change{Service: d.Name, Action: ActionRemove})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Service < changes[j].Service })
+
+	return changes
+}
+
+// diff returns the differences between a service and its deployment
+func (s Service) diff(d Deployed) []string {
+	var reasons []string
+
+	if s.Image != "" && d.Image != "" && NormalizeImageRef(s.Image) != NormalizeImageRef(d.Image) {
+		reasons = append(reasons, fmt.Sprintf("image %s -> %s", NormalizeImageRef(d.Image), NormalizeImageRef(s.Image)))
+	}
+
+	// the deployment also has the environment of the image, so only declared variables are compared
+	keys := make([]string, 0, len(s.Environment))
+	for k := range s.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := s.Environment[k]
+		if v == nil || d.Env == nil {
+			continue
+		}
+
+		current, ok := d.Env[k]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("environment %s added", k))
+		case current != *v:
+			reasons = append(reasons, fmt.Sprintf("environment %s changed", k))
+		}
+	}
+
+	if s.Deploy != nil && s.Deploy.Resources.Limits != nil {
+		limits := s.Deploy.Resources.Limits
+
+		if want, err := strconv.ParseFloat(limits.Cpus, 64); err == nil {
+			if have, err := strconv.ParseFloat(d.CPU, 64); err == nil && have != want {
+				reasons = append(reasons, fmt.Sprintf("cpus %s -> %s", d.CPU, limits.Cpus))
+			}
+		}
+
+		if want, err := ParseBytes(limits.Memory); err == nil {
+			if have, err := ParseBytes(d.Memory); err == nil && have != want {
+				reasons = append(reasons, fmt.Sprintf("memory %s -> %s", d.Memory, limits.Memory))
+			}
+		}
+	}
+
+	return reasons
+}
+
+// ParseBytes parses a byte size such as 512m or 1GiB, plain numbers are bytes
+func ParseBytes(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	s = strings.TrimSuffix(s, "b")
+	s = strings.TrimSuffix(s, "i")
+
+	unit := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k':
+			unit = 1 << 10
+		case 'm':
+			unit = 1 << 20
+		case 'g':
+			unit = 1 << 30
+		case 't':
+			unit = 1 << 40
+		}
+		if unit != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", size)
+	}
+
+	return int64(n * float64(unit)), nil
+}
+
+// NormalizeImageRef strips the default registry and adds the default tag,
+// so that references written differently can be compared
+func NormalizeImageRef(ref string) string {
+	for _, prefix := range []string{"index.docker.io/library/", "docker.io/library/", "index.docker.io/", "docker.io/"} {
+		ref = strings.TrimPrefix(ref, prefix)
+	}
+
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+
+	if i := strings.LastIndex(ref, ":"); i == -1 || strings.Contains(ref[i:], "/") {
+		ref += ":latest"
+	}
+
+	return ref
+}
@@ -0,0 +1,94 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const planFile = `
+services:
+  web:
+    image: nginx
+    environment:
+      MODE: prod
+  api:
+    image: registry.example.com/api:2
+    environment:
+      - DB_PASSWORD=new
+      - DEBUG
+    deploy:
+      resources:
+        limits:
+          cpus: "1"
+          memory: 512m
+  worker:
+    image: registry.example.com/worker:1
+    deploy:
+      resources:
+        limits:
+          memory: 1GiB
+  db:
+    image: postgres:16
+  debug:
+    image: busybox
+    profiles: [debug]
+`
+
+func TestPlan(t *testing.T) {
+	f, err := Parse(strings.NewReader(planFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deployed := []Deployed{
+		{Name: "web", Image: "index.docker.io/library/nginx:latest", Env: map[string]string{"MODE": "prod", "PATH": "/bin"}},
+		{Name: "api", Image: "registry.example.com/api:1", Env: map[string]string{"DB_PASSWORD": "old"}, CPU: "2", Memory: "512M"},
+		{Name: "worker", Image: "registry.example.com/worker:1", Memory: "1024m"},
+		{Name: "debug", Image: "busybox"},
+		{Name: "legacy", Image: "legacy"},
+	}
+
+	got := f.Plan([]string{"api", "db", "web", "worker"}, deployed)
+	want := []Change{
+		{Service: "api", Action: ActionReplace, Reasons: []string{
+			"image registry.example.com/api:1 -> registry.example.com/api:2",
+			"environment DB_PASSWORD changed",
+			"cpus 2 -> 1",
+		}},
+		{Service: "db", Action: ActionCreate},
+		{Service: "legacy", Action: ActionRemove},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Plan() = %#v, want %#v", got, want)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in    string
+		out   int64
+		error bool
+	}{
+		{in: "1024", out: 1024},
+		{in: "512m", out: 512 << 20},
+		{in: "512MB", out: 512 << 20},
+		{in: "1GiB", out: 1 << 30},
+		{in: "1.5k", out: 1536},
+		{in: "", error: true},
+		{in: "lots", error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseBytes(tt.in)
+			if (err != nil) != tt.error {
+				t.Fatalf("ParseBytes() error = %v, wantErr %v", err, tt.error)
+			}
+			if got != tt.out {
+				t.Errorf("ParseBytes() = %d, want %d", got, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v3"
+)
+
+// SelectServices returns the services to launch for the active profiles.
+// services without profiles are always active, the profile "*" activates all services.
+// if names are given, only those services are selected, regardless of their profiles.
+// dependencies of selected services are always included
+func (f *File) SelectServices(profiles []string, names []string) ([]string, error) {
+	selected := map[string]bool{}
+
+	if len(names) > 0 {
+		for _, name := range names {
+			if _, ok := f.Services[name]; !ok {
+				return nil, fmt.Errorf("no such service: %s", name)
+			}
+			selected[name] = true
+		}
+	} else {
+		for name, s := range f.Services {
+			if s.Active(profiles) {
+				selected[name] = true
+			}
+		}
+	}
+
+	// add the depends_on closure
+	queue := make([]string, 0, len(selected))
+	for name := range selected {
+		queue = append(queue, name)
+	}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		for dep := range f.Services[name].DependsOn {
+			if _, ok := f.Services[dep]; !ok {
+				return nil, fmt.Errorf("service %s depends on undefined service %s", name, dep)
+			}
+
+			if !selected[dep] {
+				selected[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	out := make([]string, 0, len(selected))
+	for name := range selected {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+
+	return out, nil
+}
+
+// Active reports whether a service is enabled by the given profiles
+func (s Service) Active(profiles []string) bool {
+	if len(s.Profiles) == 0 || slices.Contains(profiles, "*") {
+		return true
+	}
+
+	for _, p := range s.Profiles {
+		if slices.Contains(profiles, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterServices removes all services except keep from a compose template.
+// everything else in the template is left as it is
+func FilterServices(template []byte, keep []string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(template, &doc); err != nil {
+		return nil, err
+	}
+
+	services := (&Document{Root: &doc}).Node("services")
+	if services == nil || services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("no services defined")
+	}
+
+	content := services.Content[:0]
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		// kept services may alias anchors of removed ones, so aliases are resolved
+		if slices.Contains(keep, services.Content[i].Value) {
+			content = append(content, services.Content[i], (&merger{}).copyNode(services.Content[i+1]))
+		}
+	}
+	services.Content = content
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SetServiceImages sets the image of services in a compose template, such as to pin them to an id.
+// everything else in the template is left as it is
+func SetServiceImages(template []byte, images map[string]string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(template, &doc); err != nil {
+		return nil, err
+	}
+
+	d := &Document{Root: &doc}
+	for _, name := range sortedKeys(images) {
+		if err := d.SetServiceImage(name, images[name]); err != nil {
+			return nil, err
+		}
+	}
+	untagMergeKeys(&doc)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// SetServiceImage sets the image of a service in the document.
+// a service that gets its image from a merge key gets an image key of its own,
+// and values shared through anchors are replaced rather than changed
+func (d *Document) SetServiceImage(service string, image string) error {
+	services := d.Node("services")
+	if services == nil || resolve(services).Kind != yaml.MappingNode {
+		return fmt.Errorf("no services defined")
+	}
+	services = resolve(services)
+
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		if services.Content[i].Value != service {
+			continue
+		}
+
+		// an aliased service is the anchored service itself, so it becomes a mapping merging the anchor
+		s := services.Content[i+1]
+		if s.Kind == yaml.AliasNode {
+			s = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+				{Kind: yaml.ScalarNode, Value: "<<"},
+				s,
+			}}
+			services.Content[i+1] = s
+		}
+
+		if s.Kind != yaml.MappingNode {
+			return fmt.Errorf("service %s is not a mapping", service)
+		}
+
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: image}
+		for j := 0; j+1 < len(s.Content); j += 2 {
+			if s.Content[j].Value != "image" {
+				continue
+			}
+
+			if s.Content[j+1].Anchor != "" {
+				return fmt.Errorf("the image of service %s is an anchor, it can't be replaced", service)
+			}
+
+			s.Content[j+1] = value
+			return nil
+		}
+
+		s.Content = append(s.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "image"}, value)
+		return nil
+	}
+
+	return fmt.Errorf("service %s is not defined", service)
+}
+
+// untagMergeKeys clears the tag of merge keys below n, which the encoder would otherwise write as !!merge <<
+func untagMergeKeys(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].ShortTag() == "!!merge" {
+				n.Content[i].Tag = ""
+			}
+		}
+	}
+
+	for _, c := range n.Content {
+		untagMergeKeys(c)
+	}
+}
@@ -0,0 +1,173 @@
+package compose
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const profilesFile = `
+services:
+  web:
+    image: nginx
+    depends_on: [api]
+  api:
+    image: api
+    depends_on:
+      db:
+        condition: service_healthy
+  db:
+    image: postgres
+  debug:
+    image: busybox
+    profiles: [debug]
+    depends_on: [tools]
+  tools:
+    image: tools
+    profiles: [debug, ops]
+  migrate:
+    image: api
+    profiles: [jobs]
+    depends_on: [db]
+`
+
+func TestSelectServices(t *testing.T) {
+	f, err := Parse(strings.NewReader(profilesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		profiles []string
+		names    []string
+		out      []string
+		error    bool
+	}{
+		{name: "default", out: []string{"api", "db", "web"}},
+		{name: "profile", profiles: []string{"ops"}, out: []string{"api", "db", "tools", "web"}},
+		{name: "profile closure", profiles: []string{"debug"}, out: []string{"api", "db", "debug", "tools", "web"}},
+		{name: "all", profiles: []string{"*"}, out: []string{"api", "db", "debug", "migrate", "tools", "web"}},
+		{name: "names", names: []string{"api"}, out: []string{"api", "db"}},
+		{name: "names ignore profiles", names: []string{"migrate"}, out: []string{"db", "migrate"}},
+		{name: "unknown name", names: []string{"cache"}, error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.SelectServices(tt.profiles, tt.names)
+			if (err != nil) != tt.error {
+				t.Fatalf("SelectServices() error = %v, wantErr %v", err, tt.error)
+			}
+
+			if !tt.error && !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("SelectServices() = %v, want %v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestFilterServices(t *testing.T) {
+	in := `
+x-common: &common
+  restart: always
+services:
+  web:
+    <<: *common
+    image: ${IMAGE:-nginx}
+  debug: &debug
+    image: busybox
+  tools:
+    <<: *debug
+volumes:
+  data:
+`
+
+	want := `
+x-common: &common
+  restart: always
+services:
+  web:
+    restart: always
+    image: ${IMAGE:-nginx}
+  tools:
+    image: busybox
+volumes:
+  data:
+`
+
+	got, err := FilterServices([]byte(in), []string{"web", "tools"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(got)) != strings.TrimSpace(want) {
+		t.Errorf("FilterServices() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSetServiceImages(t *testing.T) {
+	in := `
+x-base: &base
+  image: nginx
+services:
+  web:
+    image: ${IMAGE:-nginx}
+  api:
+    <<: *base
+  worker: *base
+  db:
+    image: &db postgres
+  backup:
+    image: *db
+`
+
+	want := `
+x-base: &base
+  image: nginx
+services:
+  web:
+    image: nginx@sha256:1
+  api:
+    <<: *base
+    image: nginx@sha256:2
+  worker:
+    <<: *base
+    image: nginx@sha256:3
+  db:
+    image: &db postgres
+  backup:
+    image: postgres@sha256:4
+`
+
+	got, err := SetServiceImages([]byte(in), map[string]string{
+		"web":    "nginx@sha256:1",
+		"api":    "nginx@sha256:2",
+		"worker": "nginx@sha256:3",
+		"backup": "postgres@sha256:4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.TrimSpace(string(got)) != strings.TrimSpace(want) {
+		t.Errorf("SetServiceImages() =\n%s\nwant\n%s", got, want)
+	}
+
+	f, err := Parse(strings.NewReader(string(got)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Services["worker"].Image != "nginx@sha256:3" || f.Services["db"].Image != "postgres" {
+		t.Errorf("unexpected images after pinning: %+v", f.Services)
+	}
+
+	for _, images := range []map[string]string{
+		{"db": "postgres@sha256:5"},
+		{"missing": "nginx"},
+	} {
+		if _, err := SetServiceImages([]byte(in), images); err == nil {
+			t.Errorf("SetServiceImages(%v) expected an error", images)
+		}
+	}
+}
@@ -1,10 +1,258 @@
 package compose
 
+// File is a compose file as described by the compose specification.
+// keys that are not modelled here, including x- extensions, are kept in Extensions
+// so that a parsed file can be written back without losing anything
 type File struct {
-	Version  string
-	Services map[string]Service
+	Version    string             `yaml:"version,omitempty"`
+	Name       string             `yaml:"name,omitempty"`
+	Services   map[string]Service `yaml:"services,omitempty"`
+	Networks   map[string]Network `yaml:"networks,omitempty"`
+	Volumes    map[string]Volume  `yaml:"volumes,omitempty"`
+	Secrets    map[string]Secret  `yaml:"secrets,omitempty"`
+	Configs    map[string]Config  `yaml:"configs,omitempty"`
+	Extensions map[string]any     `yaml:",inline"`
 }
 
 type Service struct {
-	Image string
+	Build           *BuildConfig       `yaml:"build,omitempty"`
+	CapAdd          []string           `yaml:"cap_add,omitempty"`
+	CapDrop         []string           `yaml:"cap_drop,omitempty"`
+	Command         ShellCommand       `yaml:"command,omitempty"`
+	Configs         []ServiceConfigRef `yaml:"configs,omitempty"`
+	ContainerName   string             `yaml:"container_name,omitempty"`
+	DependsOn       DependsOn          `yaml:"depends_on,omitempty"`
+	Deploy          *Deploy            `yaml:"deploy,omitempty"`
+	Entrypoint      ShellCommand       `yaml:"entrypoint,omitempty"`
+	Environment     MappingWithEquals  `yaml:"environment,omitempty"`
+	EnvFile         EnvFiles           `yaml:"env_file,omitempty"`
+	Expose          []string           `yaml:"expose,omitempty"`
+	Healthcheck     *Healthcheck       `yaml:"healthcheck,omitempty"`
+	Hostname        string             `yaml:"hostname,omitempty"`
+	Image           string             `yaml:"image,omitempty"`
+	Init            *bool              `yaml:"init,omitempty"`
+	Labels          Labels             `yaml:"labels,omitempty"`
+	Networks        ServiceNetworks    `yaml:"networks,omitempty"`
+	Platform        string             `yaml:"platform,omitempty"`
+	Ports           Ports              `yaml:"ports,omitempty"`
+	Privileged      bool               `yaml:"privileged,omitempty"`
+	Profiles        []string           `yaml:"profiles,omitempty"`
+	PullPolicy      string             `yaml:"pull_policy,omitempty"`
+	ReadOnly        bool               `yaml:"read_only,omitempty"`
+	Restart         string             `yaml:"restart,omitempty"`
+	Secrets         []ServiceConfigRef `yaml:"secrets,omitempty"`
+	StdinOpen       bool               `yaml:"stdin_open,omitempty"`
+	StopGracePeriod string             `yaml:"stop_grace_period,omitempty"`
+	StopSignal      string             `yaml:"stop_signal,omitempty"`
+	Tty             bool               `yaml:"tty,omitempty"`
+	User            string             `yaml:"user,omitempty"`
+	Volumes         []ServiceVolume    `yaml:"volumes,omitempty"`
+	WorkingDir      string             `yaml:"working_dir,omitempty"`
+	Extensions      map[string]any     `yaml:",inline"`
+}
+
+// BuildConfig is the long syntax of build, the short syntax only sets Context
+type BuildConfig struct {
+	Context          string            `yaml:"context,omitempty"`
+	Dockerfile       string            `yaml:"dockerfile,omitempty"`
+	DockerfileInline string            `yaml:"dockerfile_inline,omitempty"`
+	Args             MappingWithEquals `yaml:"args,omitempty"`
+	Target           string            `yaml:"target,omitempty"`
+	CacheFrom        []string          `yaml:"cache_from,omitempty"`
+	CacheTo          []string          `yaml:"cache_to,omitempty"`
+	Labels           Labels            `yaml:"labels,omitempty"`
+	Network          string            `yaml:"network,omitempty"`
+	ShmSize          string            `yaml:"shm_size,omitempty"`
+	Platforms        []string          `yaml:"platforms,omitempty"`
+	Extensions       map[string]any    `yaml:",inline"`
+}
+
+// ShellCommand is a command or entrypoint.
+// the string syntax is split into words like a shell would, without running a shell
+type ShellCommand []string
+
+// MappingWithEquals is a list of KEY=VALUE or a map.
+// a nil value means the value is taken from the environment
+type MappingWithEquals map[string]*string
+
+// Labels is a list of KEY=VALUE or a map
+type Labels map[string]string
+
+// EnvFiles is a single path, a list of paths or a list of EnvFile
+type EnvFiles []EnvFile
+
+type EnvFile struct {
+	Path     string `yaml:"path"`
+	Required *bool  `yaml:"required,omitempty"`
+}
+
+// DependsOn is a list of service names or a map of service name to dependency.
+// the list syntax means the condition service_started
+type DependsOn map[string]ServiceDependency
+
+const (
+	ConditionServiceStarted               = "service_started"
+	ConditionServiceHealthy               = "service_healthy"
+	ConditionServiceCompletedSuccessfully = "service_completed_successfully"
+)
+
+type ServiceDependency struct {
+	Condition  string         `yaml:"condition,omitempty"`
+	Restart    bool           `yaml:"restart,omitempty"`
+	Required   *bool          `yaml:"required,omitempty"`
+	Extensions map[string]any `yaml:",inline"`
+}
+
+// ServiceNetworks is a list of network names or a map of network name to attachment options
+type ServiceNetworks map[string]*ServiceNetwork
+
+type ServiceNetwork struct {
+	Aliases     []string       `yaml:"aliases,omitempty"`
+	Ipv4Address string         `yaml:"ipv4_address,omitempty"`
+	Ipv6Address string         `yaml:"ipv6_address,omitempty"`
+	Priority    int            `yaml:"priority,omitempty"`
+	Extensions  map[string]any `yaml:",inline"`
+}
+
+// Ports is a list of ports in short ("127.0.0.1:8080:80/tcp") or long syntax
+type Ports []Port
+
+// Port is a published port. Target and Published are a port or a range of ports like 8000-8010
+type Port struct {
+	Name        string         `yaml:"name,omitempty"`
+	Target      string         `yaml:"target"`
+	Published   string         `yaml:"published,omitempty"`
+	HostIP      string         `yaml:"host_ip,omitempty"`
+	Protocol    string         `yaml:"protocol,omitempty"`
+	Mode        string         `yaml:"mode,omitempty"`
+	AppProtocol string         `yaml:"app_protocol,omitempty"`
+	Extensions  map[string]any `yaml:",inline"`
+}
+
+const (
+	VolumeTypeBind   = "bind"
+	VolumeTypeVolume = "volume"
+	VolumeTypeTmpfs  = "tmpfs"
+)
+
+// ServiceVolume is a volume mount in short ("./data:/data:ro") or long syntax
+type ServiceVolume struct {
+	Type        string         `yaml:"type"`
+	Source      string         `yaml:"source,omitempty"`
+	Target      string         `yaml:"target"`
+	ReadOnly    bool           `yaml:"read_only,omitempty"`
+	Consistency string         `yaml:"consistency,omitempty"`
+	Bind        *BindOptions   `yaml:"bind,omitempty"`
+	Volume      *VolumeOptions `yaml:"volume,omitempty"`
+	Tmpfs       *TmpfsOptions  `yaml:"tmpfs,omitempty"`
+	Extensions  map[string]any `yaml:",inline"`
+}
+
+type BindOptions struct {
+	Propagation    string `yaml:"propagation,omitempty"`
+	CreateHostPath *bool  `yaml:"create_host_path,omitempty"`
+	SELinux        string `yaml:"selinux,omitempty"`
+}
+
+type VolumeOptions struct {
+	NoCopy  bool   `yaml:"nocopy,omitempty"`
+	Subpath string `yaml:"subpath,omitempty"`
+}
+
+type TmpfsOptions struct {
+	Size string  `yaml:"size,omitempty"`
+	Mode *uint32 `yaml:"mode,omitempty"`
+}
+
+// ServiceConfigRef grants a service access to a secret or config.
+// the short syntax only sets Source
+type ServiceConfigRef struct {
+	Source     string         `yaml:"source"`
+	Target     string         `yaml:"target,omitempty"`
+	UID        string         `yaml:"uid,omitempty"`
+	GID        string         `yaml:"gid,omitempty"`
+	Mode       *uint32        `yaml:"mode,omitempty"`
+	Extensions map[string]any `yaml:",inline"`
+}
+
+type Healthcheck struct {
+	Test          HealthcheckTest `yaml:"test,omitempty"`
+	Interval      string          `yaml:"interval,omitempty"`
+	Timeout       string          `yaml:"timeout,omitempty"`
+	StartPeriod   string          `yaml:"start_period,omitempty"`
+	StartInterval string          `yaml:"start_interval,omitempty"`
+	Retries       *uint64         `yaml:"retries,omitempty"`
+	Disable       bool            `yaml:"disable,omitempty"`
+	Extensions    map[string]any  `yaml:",inline"`
+}
+
+// HealthcheckTest is the healthcheck command.
+// the string syntax is equivalent to ["CMD-SHELL", <string>]
+type HealthcheckTest []string
+
+type Deploy struct {
+	Mode          string         `yaml:"mode,omitempty"`
+	Replicas      *uint64        `yaml:"replicas,omitempty"`
+	Labels        Labels         `yaml:"labels,omitempty"`
+	Resources     Resources      `yaml:"resources,omitempty"`
+	RestartPolicy *RestartPolicy `yaml:"restart_policy,omitempty"`
+	Extensions    map[string]any `yaml:",inline"`
+}
+
+type Resources struct {
+	Limits       *Resource `yaml:"limits,omitempty"`
+	Reservations *Resource `yaml:"reservations,omitempty"`
+}
+
+type Resource struct {
+	Cpus       string         `yaml:"cpus,omitempty"`
+	Memory     string         `yaml:"memory,omitempty"`
+	Pids       int64          `yaml:"pids,omitempty"`
+	Extensions map[string]any `yaml:",inline"`
+}
+
+type RestartPolicy struct {
+	Condition   string  `yaml:"condition,omitempty"`
+	Delay       string  `yaml:"delay,omitempty"`
+	MaxAttempts *uint64 `yaml:"max_attempts,omitempty"`
+	Window      string  `yaml:"window,omitempty"`
+}
+
+type Network struct {
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+	External   bool              `yaml:"external,omitempty"`
+	Internal   bool              `yaml:"internal,omitempty"`
+	Attachable bool              `yaml:"attachable,omitempty"`
+	Labels     Labels            `yaml:"labels,omitempty"`
+	Extensions map[string]any    `yaml:",inline"`
+}
+
+type Volume struct {
+	Name       string            `yaml:"name,omitempty"`
+	Driver     string            `yaml:"driver,omitempty"`
+	DriverOpts map[string]string `yaml:"driver_opts,omitempty"`
+	External   bool              `yaml:"external,omitempty"`
+	Labels     Labels            `yaml:"labels,omitempty"`
+	Extensions map[string]any    `yaml:",inline"`
+}
+
+type Secret struct {
+	Name        string         `yaml:"name,omitempty"`
+	File        string         `yaml:"file,omitempty"`
+	Environment string         `yaml:"environment,omitempty"`
+	External    bool           `yaml:"external,omitempty"`
+	Labels      Labels         `yaml:"labels,omitempty"`
+	Extensions  map[string]any `yaml:",inline"`
+}
+
+type Config struct {
+	Name        string         `yaml:"name,omitempty"`
+	File        string         `yaml:"file,omitempty"`
+	Environment string         `yaml:"environment,omitempty"`
+	Content     string         `yaml:"content,omitempty"`
+	External    bool           `yaml:"external,omitempty"`
+	Labels      Labels         `yaml:"labels,omitempty"`
+	Extensions  map[string]any `yaml:",inline"`
 }
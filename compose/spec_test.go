@@ -0,0 +1,287 @@
+package compose
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRoundTrip(t *testing.T) {
+	spconfig := spew.NewDefaultConfig()
+	spconfig.SortKeys = true
+
+	files, err := filepath.Glob("testdata/*.y*ml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			parsed, err := ParseFile(file)
+			if err != nil {
+				t.Fatalf("ParseFile() error = %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := Encode(&buf, parsed); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			reparsed, err := Parse(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("Parse() error = %v\n%s", err, buf.String())
+			}
+
+			if !reflect.DeepEqual(parsed, reparsed) {
+				t.Errorf("round trip differs:\n%s\ngot  %s\nwant %s", buf.String(), spconfig.Sdump(reparsed), spconfig.Sdump(parsed))
+			}
+		})
+	}
+}
+
+func TestParseKitchenSink(t *testing.T) {
+	f, err := ParseFile("testdata/kitchen-sink.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	str := func(s string) *string { return &s }
+	boolean := func(b bool) *bool { return &b }
+	uint64p := func(i uint64) *uint64 { return &i }
+	uint32p := func(i uint32) *uint32 { return &i }
+
+	api := f.Services["api"]
+	db := f.Services["db"]
+	migrate := f.Services["migrate"]
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"version", f.Version, "3.9"},
+		{"name", f.Name, "sink"},
+		{"file extensions", f.Extensions["x-logging"], map[string]any{"driver": "json-file", "options": map[string]any{"max-size": "10m"}}},
+		{"build", api.Build, &BuildConfig{
+			Context:    "./api",
+			Dockerfile: "Dockerfile.prod",
+			Target:     "runtime",
+			Args:       MappingWithEquals{"GO_VERSION": str("1.21"), "GIT_COMMIT": nil},
+			CacheFrom:  []string{"registry.example.com/api:cache"},
+		}},
+		{"image", api.Image, "registry.example.com/api:${TAG:-latest}"},
+		{"entrypoint", api.Entrypoint, ShellCommand{"/usr/bin/tini", "--"}},
+		{"command string", api.Command, ShellCommand{"/app/api", "serve", "--listen", ":8080", "--name", "my api", "escaped word"}},
+		{"command list", migrate.Command, ShellCommand{"/app/api", "migrate"}},
+		{"environment merge", api.Environment, MappingWithEquals{
+			"TZ":           str("Europe/Berlin"),
+			"DEBUG":        nil,
+			"DATABASE_URL": str("postgres://api@db/api"),
+			"WORKERS":      str("4"),
+		}},
+		{"environment alias", migrate.Environment, MappingWithEquals{"TZ": str("Europe/Berlin"), "DEBUG": nil}},
+		{"env_file", api.EnvFile, EnvFiles{{Path: ".env"}, {Path: "./api.env", Required: boolean(false)}}},
+		{"ports", api.Ports, Ports{
+			{Target: "8080", Published: "8080", Mode: "ingress"},
+			{Target: "9090", Published: "9090", HostIP: "127.0.0.1", Protocol: "tcp", Mode: "ingress"},
+			{Target: "9091", Published: "9091", HostIP: "::1", Mode: "ingress"},
+			{Target: "7000-7002", Published: "7000-7002", Protocol: "udp", Mode: "ingress"},
+			{Target: "443", Published: "8443", Protocol: "tcp", Mode: "host"},
+		}},
+		{"volumes", api.Volumes, []ServiceVolume{
+			{Type: VolumeTypeBind, Source: "./config", Target: "/etc/api", ReadOnly: true, Bind: &BindOptions{CreateHostPath: boolean(true)}},
+			{Type: VolumeTypeVolume, Source: "cache", Target: "/var/cache/api", Volume: &VolumeOptions{NoCopy: true}},
+			{Type: VolumeTypeVolume, Target: "/tmp"},
+			{Type: VolumeTypeTmpfs, Target: "/run", Tmpfs: &TmpfsOptions{Size: "64m"}},
+		}},
+		{"networks map", api.Networks, ServiceNetworks{"front": {Aliases: []string{"api.internal"}}, "back": nil}},
+		{"networks list", db.Networks, ServiceNetworks{"back": nil}},
+		{"depends_on map", api.DependsOn, DependsOn{
+			"db":      {Condition: ConditionServiceHealthy, Restart: true},
+			"migrate": {Condition: ConditionServiceCompletedSuccessfully},
+		}},
+		{"depends_on list", migrate.DependsOn, DependsOn{"db": {Condition: ConditionServiceStarted}}},
+		{"healthcheck string", api.Healthcheck, &Healthcheck{
+			Test:        HealthcheckTest{"CMD-SHELL", "curl -f http://localhost:8080/health"},
+			Interval:    "30s",
+			Timeout:     "5s",
+			StartPeriod: "10s",
+			Retries:     uint64p(3),
+		}},
+		{"healthcheck list", db.Healthcheck.Test, HealthcheckTest{"CMD", "pg_isready", "-U", "api"}},
+		{"deploy", api.Deploy, &Deploy{
+			Mode:     "replicated",
+			Replicas: uint64p(2),
+			Resources: Resources{
+				Limits:       &Resource{Cpus: "0.5", Memory: "512M"},
+				Reservations: &Resource{Memory: "128M"},
+			},
+			RestartPolicy: &RestartPolicy{Condition: "on-failure", MaxAttempts: uint64p(3)},
+		}},
+		{"labels map", api.Labels, Labels{"traefik.enable": "true", "com.example.team": "backend"}},
+		{"labels list", db.Labels, Labels{"com.example.team": "data", "com.example.backup": ""}},
+		{"secrets", api.Secrets, []ServiceConfigRef{{Source: "db_password"}, {Source: "api_key", Target: "/run/secrets/key", Mode: uint32p(0440)}}},
+		{"configs", api.Configs, []ServiceConfigRef{{Source: "api_config"}}},
+		{"profiles", migrate.Profiles, []string{"app"}},
+		{"service extensions", api.Extensions["x-kraud-replicas"], 2},
+		{"unmodelled keys", db.Extensions["logging"], f.Extensions["x-logging"]},
+		{"network", f.Networks["back"], Network{Internal: true, DriverOpts: map[string]string{"com.docker.network.driver.mtu": "1400"}}},
+		{"volume", f.Volumes["cache"], Volume{External: true, Name: "shared-cache"}},
+		{"secret", f.Secrets["api_key"], Secret{Environment: "API_KEY"}},
+		{"config", f.Configs["api_config"], Config{Content: "listen: :8080\n"}},
+	}
+
+	spconfig := spew.NewDefaultConfig()
+	spconfig.SortKeys = true
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %s\nwant %s", spconfig.Sdump(tt.got), spconfig.Sdump(tt.want))
+			}
+		})
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		out   Port
+		error bool
+	}{
+		{name: "target", in: "80", out: Port{Target: "80", Mode: "ingress"}},
+		{name: "published", in: "8080:80", out: Port{Target: "80", Published: "8080", Mode: "ingress"}},
+		{name: "host ip", in: "127.0.0.1:8080:80/udp", out: Port{Target: "80", Published: "8080", HostIP: "127.0.0.1", Protocol: "udp", Mode: "ingress"}},
+		{name: "ipv6", in: "[::1]:8080:80", out: Port{Target: "80", Published: "8080", HostIP: "::1", Mode: "ingress"}},
+		{name: "published range", in: "8000-8010:80", out: Port{Target: "80", Published: "8000-8010", Mode: "ingress"}},
+		{name: "range", in: "3000-3001", out: Port{Target: "3000-3001", Mode: "ingress"}},
+		{name: "ranges", in: "8000-8001:3000-3001", out: Port{Target: "3000-3001", Published: "8000-8001", Mode: "ingress"}},
+		{name: "mismatched range", in: "8000-8002:3000-3001", error: true},
+		{name: "invalid", in: "http", error: true},
+		{name: "too many parts", in: "1:2:3:4", error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePort(tt.in)
+			if (err != nil) != tt.error {
+				t.Fatalf("ParsePort() error = %v, wantErr %v", err, tt.error)
+			}
+
+			if !tt.error && !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("ParsePort() = %+v, want %+v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestPortRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{name: "target", in: `"80"`, out: `"80"`},
+		{name: "range", in: `"3000-3001"`, out: "3000-3001"},
+		{name: "ranges", in: `"127.0.0.1:8000-8001:3000-3001/udp"`, out: "127.0.0.1:8000-8001:3000-3001/udp"},
+		{name: "any published", in: `"[::1]::80"`, out: "'[::1]::80'"},
+		{name: "long range", in: "{target: 3000-3001, published: 8000-8001, mode: host}", out: "target: 3000-3001\npublished: 8000-8001\nmode: host"},
+		{name: "long", in: "{target: 80, published: 8080, app_protocol: http}", out: `target: "80"` + "\npublished: \"8080\"\napp_protocol: http"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var port Port
+			if err := yaml.Unmarshal([]byte(tt.in), &port); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := yaml.Marshal(port)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := strings.TrimSpace(string(out)); got != tt.out {
+				t.Errorf("got %s, want %s", got, tt.out)
+			}
+
+			var reparsed Port
+			if err := yaml.Unmarshal(out, &reparsed); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(port, reparsed) {
+				t.Errorf("round trip differs: got %+v, want %+v", reparsed, port)
+			}
+		})
+	}
+}
+
+func TestParseVolume(t *testing.T) {
+	createHostPath := true
+
+	tests := []struct {
+		name  string
+		in    string
+		out   ServiceVolume
+		error bool
+	}{
+		{name: "anonymous", in: "/data", out: ServiceVolume{Type: VolumeTypeVolume, Target: "/data"}},
+		{name: "named", in: "data:/data", out: ServiceVolume{Type: VolumeTypeVolume, Source: "data", Target: "/data"}},
+		{name: "bind", in: "./data:/data:ro,z", out: ServiceVolume{Type: VolumeTypeBind, Source: "./data", Target: "/data", ReadOnly: true, Bind: &BindOptions{CreateHostPath: &createHostPath, SELinux: "z"}}},
+		{name: "home", in: "~/data:/data:rshared", out: ServiceVolume{Type: VolumeTypeBind, Source: "~/data", Target: "/data", Bind: &BindOptions{CreateHostPath: &createHostPath, Propagation: "rshared"}}},
+		{name: "selinux on volume", in: "data:/data:z", error: true},
+		{name: "unknown option", in: "data:/data:fast", error: true},
+		{name: "empty", in: "", error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVolume(tt.in)
+			if (err != nil) != tt.error {
+				t.Fatalf("ParseVolume() error = %v, wantErr %v", err, tt.error)
+			}
+
+			if !tt.error && !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("ParseVolume() = %+v, want %+v", got, tt.out)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		out   []string
+		error bool
+	}{
+		{name: "empty", in: "", out: nil},
+		{name: "words", in: "  echo hello\tworld ", out: []string{"echo", "hello", "world"}},
+		{name: "single quotes", in: `sh -c 'echo "$HOME"'`, out: []string{"sh", "-c", `echo "$HOME"`}},
+		{name: "double quotes", in: `echo "a \"b\" \c"`, out: []string{"echo", `a "b" \c`}},
+		{name: "escapes", in: `echo a\ b`, out: []string{"echo", "a b"}},
+		{name: "adjacent quotes", in: `--name="my "'app'`, out: []string{"--name=my app"}},
+		{name: "empty quotes", in: `echo ""`, out: []string{"echo", ""}},
+		{name: "unterminated", in: `echo "hello`, error: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitCommand(tt.in)
+			if (err != nil) != tt.error {
+				t.Fatalf("SplitCommand() error = %v, wantErr %v", err, tt.error)
+			}
+
+			if !tt.error && !reflect.DeepEqual(got, tt.out) {
+				t.Errorf("SplitCommand() = %q, want %q", got, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,562 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// this file implements the short syntaxes of the compose specification.
+// files are always written back in long syntax, unless the short syntax is lossless
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+func isNull(n *yaml.Node) bool {
+	n = resolve(n)
+	return n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null"
+}
+
+func scalar(n *yaml.Node) (string, error) {
+	n = resolve(n)
+	if n.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("line %d: expected a scalar", n.Line)
+	}
+	return n.Value, nil
+}
+
+// syntaxError is reported as a yaml.TypeError, so that decoding continues with the next field
+func syntaxError(n *yaml.Node, err error) error {
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: %v", n.Line, err)}}
+}
+
+// mapping iterates the key value pairs of a mapping node.
+// merge keys (<<) are iterated first, so explicit keys take precedence
+func mapping(n *yaml.Node, fn func(key string, value *yaml.Node) error) error {
+	n = resolve(n)
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping", n.Line)
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].ShortTag() != "!!merge" {
+			continue
+		}
+
+		merge := resolve(n.Content[i+1])
+		if merge.Kind != yaml.SequenceNode {
+			merge = &yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{merge}}
+		}
+
+		for _, m := range merge.Content {
+			if err := mapping(m, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].ShortTag() == "!!merge" {
+			continue
+		}
+
+		key, err := scalar(n.Content[i])
+		if err != nil {
+			return err
+		}
+
+		if err := fn(key, n.Content[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *ShellCommand) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		words, err := SplitCommand(value.Value)
+		if err != nil {
+			return syntaxError(value, err)
+		}
+		*c = words
+		return nil
+	}
+
+	return value.Decode((*[]string)(c))
+}
+
+// SplitCommand splits a command string into words like a posix shell,
+// without expanding anything
+func SplitCommand(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case ch == '\\':
+			inWord = true
+			if i+1 < len(s) {
+				i++
+				if s[i] != '\n' {
+					word.WriteByte(s[i])
+				}
+			}
+
+		case ch == '\'':
+			inWord = true
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated single quote in %q", s)
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+
+		case ch == '"':
+			inWord = true
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) != -1 {
+					i++
+				}
+				word.WriteByte(s[i])
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated double quote in %q", s)
+			}
+
+		default:
+			inWord = true
+			word.WriteByte(ch)
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+func (m *MappingWithEquals) UnmarshalYAML(value *yaml.Node) error {
+	out := MappingWithEquals{}
+
+	value = resolve(value)
+	if value.Kind == yaml.SequenceNode {
+		for _, item := range value.Content {
+			kv, err := scalar(item)
+			if err != nil {
+				return err
+			}
+
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				out[k] = nil
+				continue
+			}
+			out[k] = &v
+		}
+
+		*m = out
+		return nil
+	}
+
+	err := mapping(value, func(key string, n *yaml.Node) error {
+		if isNull(n) {
+			out[key] = nil
+			return nil
+		}
+
+		v, err := scalar(n)
+		if err != nil {
+			return err
+		}
+		out[key] = &v
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	*m = out
+	return nil
+}
+
+func (l *Labels) UnmarshalYAML(value *yaml.Node) error {
+	var m MappingWithEquals
+	if err := m.UnmarshalYAML(value); err != nil {
+		return err
+	}
+
+	out := Labels{}
+	for k, v := range m {
+		if v != nil {
+			out[k] = *v
+		} else {
+			out[k] = ""
+		}
+	}
+
+	*l = out
+	return nil
+}
+
+func (e *EnvFiles) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		*e = EnvFiles{{Path: value.Value}}
+		return nil
+	}
+
+	return value.Decode((*[]EnvFile)(e))
+}
+
+func (e *EnvFile) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		*e = EnvFile{Path: value.Value}
+		return nil
+	}
+
+	type plain EnvFile
+	return value.Decode((*plain)(e))
+}
+
+func (e EnvFile) MarshalYAML() (interface{}, error) {
+	if e.Required == nil {
+		return e.Path, nil
+	}
+
+	type plain EnvFile
+	return plain(e), nil
+}
+
+func (d *DependsOn) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.SequenceNode {
+		var names []string
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+
+		out := DependsOn{}
+		for _, name := range names {
+			out[name] = ServiceDependency{Condition: ConditionServiceStarted}
+		}
+
+		*d = out
+		return nil
+	}
+
+	return value.Decode((*map[string]ServiceDependency)(d))
+}
+
+func (s *ServiceNetworks) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.SequenceNode {
+		var names []string
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+
+		out := ServiceNetworks{}
+		for _, name := range names {
+			out[name] = nil
+		}
+
+		*s = out
+		return nil
+	}
+
+	return value.Decode((*map[string]*ServiceNetwork)(s))
+}
+
+func (c *BuildConfig) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		*c = BuildConfig{Context: value.Value}
+		return nil
+	}
+
+	type plain BuildConfig
+	return value.Decode((*plain)(c))
+}
+
+func (c *ServiceConfigRef) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		*c = ServiceConfigRef{Source: value.Value}
+		return nil
+	}
+
+	type plain ServiceConfigRef
+	return value.Decode((*plain)(c))
+}
+
+func (c ServiceConfigRef) MarshalYAML() (interface{}, error) {
+	if c.Target == "" && c.UID == "" && c.GID == "" && c.Mode == nil && len(c.Extensions) == 0 {
+		return c.Source, nil
+	}
+
+	type plain ServiceConfigRef
+	return plain(c), nil
+}
+
+func (t *HealthcheckTest) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		*t = HealthcheckTest{"CMD-SHELL", value.Value}
+		return nil
+	}
+
+	return value.Decode((*[]string)(t))
+}
+
+func (p *Ports) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: ports must be a list", value.Line)
+	}
+
+	var out Ports
+	for _, item := range value.Content {
+		var port Port
+		if err := item.Decode(&port); err != nil {
+			return err
+		}
+		out = append(out, port)
+	}
+
+	*p = out
+	return nil
+}
+
+func (p *Port) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		parsed, err := ParsePort(value.Value)
+		if err != nil {
+			return syntaxError(value, err)
+		}
+		*p = parsed
+		return nil
+	}
+
+	type plain Port
+	if err := value.Decode((*plain)(p)); err != nil {
+		return err
+	}
+
+	if _, _, err := parsePortRange(p.Target); err != nil {
+		return syntaxError(value, fmt.Errorf("invalid target %q: %w", p.Target, err))
+	}
+
+	return nil
+}
+
+// MarshalYAML writes the short syntax if it can express the port, so ranges stay ranges
+func (p Port) MarshalYAML() (interface{}, error) {
+	if p.Name != "" || p.AppProtocol != "" || p.Mode != "ingress" || len(p.Extensions) > 0 {
+		type plain Port
+		return plain(p), nil
+	}
+
+	s := p.Target
+	if p.Published != "" {
+		s = p.Published + ":" + s
+	}
+	if p.HostIP != "" {
+		hostIP := p.HostIP
+		if strings.Contains(hostIP, ":") {
+			hostIP = "[" + hostIP + "]"
+		}
+		if p.Published == "" {
+			// the host ip needs a published port, an empty one picks any
+			s = ":" + s
+		}
+		s = hostIP + ":" + s
+	}
+	if p.Protocol != "" {
+		s += "/" + p.Protocol
+	}
+
+	return s, nil
+}
+
+// ParsePort parses the short port syntax [[HOST_IP:]PUBLISHED:]TARGET[/PROTOCOL].
+// target and published may be ranges, which are kept as they are
+func ParsePort(s string) (Port, error) {
+	spec, protocol, _ := strings.Cut(s, "/")
+
+	hostIP := ""
+	if strings.HasPrefix(spec, "[") {
+		end := strings.Index(spec, "]:")
+		if end == -1 {
+			return Port{}, fmt.Errorf("invalid port %q", s)
+		}
+		hostIP = spec[1:end]
+		spec = spec[end+2:]
+	}
+
+	parts := strings.Split(spec, ":")
+	published := ""
+	switch len(parts) {
+	case 1:
+	case 2:
+		published = parts[0]
+	case 3:
+		if hostIP != "" {
+			return Port{}, fmt.Errorf("invalid port %q", s)
+		}
+		hostIP, published = parts[0], parts[1]
+	default:
+		return Port{}, fmt.Errorf("invalid port %q", s)
+	}
+	target := parts[len(parts)-1]
+
+	targetStart, targetEnd, err := parsePortRange(target)
+	if err != nil {
+		return Port{}, fmt.Errorf("invalid port %q: %w", s, err)
+	}
+
+	// a single target port may be published on any port of a range,
+	// a range of target ports needs a range of the same size
+	if published != "" && targetStart != targetEnd {
+		publishedStart, publishedEnd, err := parsePortRange(published)
+		if err != nil {
+			return Port{}, fmt.Errorf("invalid port %q: %w", s, err)
+		}
+
+		if publishedEnd-publishedStart != targetEnd-targetStart {
+			return Port{}, fmt.Errorf("invalid port %q: published and target ranges differ in size", s)
+		}
+	}
+
+	return Port{
+		Target:    target,
+		Published: published,
+		HostIP:    hostIP,
+		Protocol:  protocol,
+		Mode:      "ingress",
+	}, nil
+}
+
+func parsePortRange(s string) (uint32, uint32, error) {
+	from, to, isRange := strings.Cut(s, "-")
+
+	start, err := strconv.ParseUint(from, 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !isRange {
+		return uint32(start), uint32(start), nil
+	}
+
+	end, err := strconv.ParseUint(to, 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if end < start {
+		return 0, 0, fmt.Errorf("invalid range %s", s)
+	}
+
+	return uint32(start), uint32(end), nil
+}
+
+func (v *ServiceVolume) UnmarshalYAML(value *yaml.Node) error {
+	value = resolve(value)
+	if value.Kind == yaml.ScalarNode {
+		parsed, err := ParseVolume(value.Value)
+		if err != nil {
+			return syntaxError(value, err)
+		}
+		*v = parsed
+		return nil
+	}
+
+	type plain ServiceVolume
+	return value.Decode((*plain)(v))
+}
+
+// ParseVolume parses the short volume syntax [SOURCE:]TARGET[:OPTIONS]
+func ParseVolume(s string) (ServiceVolume, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 || s == "" {
+		return ServiceVolume{}, fmt.Errorf("invalid volume %q", s)
+	}
+
+	// anonymous volume
+	if len(parts) == 1 {
+		return ServiceVolume{Type: VolumeTypeVolume, Target: s}, nil
+	}
+
+	v := ServiceVolume{
+		Type:   VolumeTypeVolume,
+		Source: parts[0],
+		Target: parts[1],
+	}
+
+	if isBindSource(v.Source) {
+		createHostPath := true
+		v.Type = VolumeTypeBind
+		v.Bind = &BindOptions{CreateHostPath: &createHostPath}
+	}
+
+	if len(parts) < 3 {
+		return v, nil
+	}
+
+	for _, opt := range strings.Split(parts[2], ",") {
+		switch opt {
+		case "ro":
+			v.ReadOnly = true
+		case "rw":
+			v.ReadOnly = false
+		case "z", "Z":
+			if v.Bind == nil {
+				return v, fmt.Errorf("invalid volume %q: %s only applies to bind mounts", s, opt)
+			}
+			v.Bind.SELinux = opt
+		case "shared", "rshared", "slave", "rslave", "private", "rprivate":
+			if v.Bind == nil {
+				return v, fmt.Errorf("invalid volume %q: %s only applies to bind mounts", s, opt)
+			}
+			v.Bind.Propagation = opt
+		case "nocopy":
+			if v.Type != VolumeTypeVolume {
+				return v, fmt.Errorf("invalid volume %q: nocopy only applies to volumes", s)
+			}
+			v.Volume = &VolumeOptions{NoCopy: true}
+		case "cached", "delegated", "consistent":
+			v.Consistency = opt
+		default:
+			return v, fmt.Errorf("invalid volume %q: unknown option %s", s, opt)
+		}
+	}
+
+	return v, nil
+}
+
+func isBindSource(source string) bool {
+	return strings.HasPrefix(source, ".") ||
+		strings.HasPrefix(source, "/") ||
+		strings.HasPrefix(source, "~") ||
+		strings.HasPrefix(source, "$")
+}
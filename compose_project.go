@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/kraudcloud/cli/api"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+)
+
+func DownCMD() *cobra.Command {
+	namespace := composeProjectName()
+	volumes := false
+
+	c := &cobra.Command{
+		Use:   "down",
+		Short: "stop an application",
+		Long: `Stop an application launched with kra up.
+
+All deployments of the project are deleted. Volumes are kept, so the next kra up
+picks them up again, unless --volumes is given. The namespace itself is deleted
+once nothing is left in it.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if namespace == "" {
+				fmt.Fprintf(cmd.ErrOrStderr(), "namespace is required\n")
+				os.Exit(1)
+			}
+
+			overview, err := API().NamespaceOverview(cmd.Context(), namespace)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error getting namespace %s: %v\n", namespace, err)
+				os.Exit(1)
+			}
+
+			failed := false
+			for _, d := range overview.Deployments {
+				err := API().DeleteDeployment(cmd.Context(), namespace, d.Name)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error deleting deployment %s: %v\n", d.Name, err)
+					failed = true
+					continue
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "deployment %q deleted\n", d.Name)
+			}
+
+			if volumes {
+				for _, v := range overview.Volumes {
+					err := API().DeleteVolume(cmd.Context(), v.ID)
+					if err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "error deleting volume %s: %v\n", v.Name, err)
+						failed = true
+						continue
+					}
+
+					fmt.Fprintf(cmd.OutOrStdout(), "volume %q deleted\n", v.Name)
+				}
+			}
+
+			if failed {
+				os.Exit(1)
+			}
+
+			if !volumes && len(overview.Volumes) > 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "keeping namespace %q with %d volumes, use --volumes to delete them\n", namespace, len(overview.Volumes))
+				return
+			}
+
+			err = API().DeleteNamespace(cmd.Context(), namespace, true)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error deleting namespace: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "namespace %q deleted\n", namespace)
+		},
+	}
+
+	c.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace to use")
+	c.Flags().BoolVar(&volumes, "volumes", volumes, "also delete the volumes of the application")
+
+	return c
+}
+
+func composePsCMD() *cobra.Command {
+	namespace := composeProjectName()
+
+	c := &cobra.Command{
+		Use:   "ps [SERVICE...]",
+		Short: "List the pods of an application launched with kra up",
+		Run: func(cmd *cobra.Command, args []string) {
+			pods, err := composeProjectPods(cmd.Context(), namespace, args)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error getting pods: %v\n", err)
+				os.Exit(1)
+			}
+
+			switch OUTPUT_FORMAT {
+			case "json":
+				identJSONEncoder(cmd.OutOrStdout(), pods)
+			default:
+				table := NewTable("name", "aid", "cpu", "mem", "status", "image")
+				for _, pod := range pods {
+					var image string
+					if len(pod.Containers) > 0 {
+						image = strings.TrimPrefix(pod.Containers[0].ImageName, "index.docker.io/library/")
+					}
+
+					table.AddRow(pod.Name, pod.AID, pod.CPU, pod.Mem, podStatus(pod), image)
+				}
+				table.Print()
+			}
+		},
+	}
+
+	c.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace to use")
+
+	return c
+}
+
+func composeLogsCMD() *cobra.Command {
+	namespace := composeProjectName()
+	follow := false
+
+	c := &cobra.Command{
+		Use:     "logs [SERVICE...]",
+		Short:   "Show the logs of all services of an application launched with kra up",
+		Aliases: []string{"log"},
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+
+			pods, err := composeProjectPods(ctx, namespace, args)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error getting pods: %v\n", err)
+				os.Exit(1)
+			}
+
+			width := 0
+			for _, pod := range pods {
+				if len(pod.Name) > width {
+					width = len(pod.Name)
+				}
+			}
+
+			colors := []color.Attribute{color.FgCyan, color.FgYellow, color.FgGreen, color.FgMagenta, color.FgBlue, color.FgRed}
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			for i, pod := range pods {
+				prefix := color.New(colors[i%len(colors)]).Sprintf("%-*s | ", width, pod.Name)
+				stdout := &prefixWriter{mu: &mu, w: cmd.OutOrStdout(), prefix: prefix}
+				stderr := &prefixWriter{mu: &mu, w: cmd.ErrOrStderr(), prefix: prefix}
+
+				wg.Add(1)
+				go func(pod api.KraudPod) {
+					defer wg.Done()
+					defer stdout.Flush()
+					defer stderr.Flush()
+
+					err := containerLogs(ctx, pod.AID, follow, "all", stdout, stderr)
+					if err != nil {
+						fmt.Fprintf(stderr, "error getting logs: %v\n", err)
+					}
+				}(pod)
+			}
+
+			wg.Wait()
+		},
+	}
+
+	c.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace to use")
+	c.Flags().BoolVarP(&follow, "follow", "f", follow, "Keep tailing logs.")
+
+	return c
+}
+
+// composeProjectDeployments returns the deployments in a project's namespace with their pods, optionally only of the given services
+func composeProjectDeployments(ctx context.Context, namespace string, services []string) ([]api.KraudDeployment, error) {
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace is required")
+	}
+
+	overview, err := API().NamespaceOverview(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range services {
+		if !slices.ContainsFunc(overview.Deployments, func(d api.KrDeployment) bool { return d.Name == name }) {
+			return nil, fmt.Errorf("no such service: %s", name)
+		}
+	}
+
+	deployments := make([]api.KraudDeployment, 0, len(overview.Deployments))
+	for _, d := range overview.Deployments {
+		if len(services) > 0 && !slices.Contains(services, d.Name) {
+			continue
+		}
+
+		deployment, err := API().InspectDeployment(ctx, d.AID)
+		if err != nil {
+			return nil, err
+		}
+
+		if deployment.Name == "" {
+			deployment.Name = d.Name
+		}
+
+		deployments = append(deployments, *deployment)
+	}
+
+	sort.Slice(deployments, func(i, j int) bool { return deployments[i].Name < deployments[j].Name })
+
+	return deployments, nil
+}
+
+// composeProjectPods returns the pods of the deployments in a project's namespace, optionally only of the given services
+func composeProjectPods(ctx context.Context, namespace string, services []string) ([]api.KraudPod, error) {
+	deployments, err := composeProjectDeployments(ctx, namespace, services)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]api.KraudPod, 0, len(deployments))
+	for _, d := range deployments {
+		// deployments that aren't scheduled yet have no pod
+		if len(d.Pods) == 0 {
+			pods = append(pods, api.KraudPod{AID: d.AID, Name: d.Name, Namespace: namespace})
+			continue
+		}
+
+		pods = append(pods, d.Pods...)
+	}
+
+	return pods, nil
+}
+
+// prefixWriter writes whole lines with a prefix, so the output of concurrent writers doesn't interleave
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i == -1 {
+			break
+		}
+
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+
+	return len(b), nil
+}
+
+// Flush writes an incomplete last line
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+
+	line := append(p.buf, '\n')
+	p.buf = nil
+	return p.writeLine(line)
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := fmt.Fprintf(p.w, "%s%s", p.prefix, line)
+	return err
+}
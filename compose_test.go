@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/kraudcloud/cli/compose"
+	"github.com/kraudcloud/cli/compose/envparser"
+)
+
+func TestLintKraudUnsupported(t *testing.T) {
+	doc, issues := compose.Lint([]byte(`
+services:
+  on:
+    image: nginx
+    privileged: true
+    sysctls:
+      net.core.somaxconn: 1024
+  off:
+    image: nginx
+    privileged: false
+    sysctls: {}
+    devices: []
+  templated:
+    image: nginx
+    privileged: ${PRIVILEGED:-false}
+    security_opt: ${SECURITY_OPT}
+`), nil)
+	if doc == nil {
+		t.Fatalf("Lint() = %v", issues)
+	}
+
+	var got []string
+	for _, issue := range lintKraud(context.Background(), doc, false) {
+		got = append(got, issue.Path)
+	}
+
+	want := []string{"services.on.privileged", "services.on.sysctls"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lintKraud() = %v, want %v", got, want)
+	}
+}
+
+func TestMaskSecretEnv(t *testing.T) {
+	env := envparser.Env{
+		"DB_PASSWORD": {Value: "hunter2", Source: ".env"},
+		"LISTED":      {Value: "listed", Source: "--env"},
+		"KEYRING":     {Value: "keyring", Source: "keyring"},
+		"PASS":        {Value: "pass", Source: "pass:app/prod"},
+		"SOPS":        {Value: "sops", Source: "sops:secrets.env"},
+		"EXEC":        {Value: "exec", Source: "exec:vault read"},
+		"EMPTY":       {Value: "", Source: "keyring:app"},
+		"PLAIN":       {Value: "plain", Source: "environment"},
+	}
+
+	got := maskSecretEnv(env, []string{"LISTED"})
+	want := map[string]string{
+		"DB_PASSWORD": "<masked>",
+		"LISTED":      "<masked>",
+		"KEYRING":     "<masked>",
+		"PASS":        "<masked>",
+		"SOPS":        "<masked>",
+		"EXEC":        "<masked>",
+		"EMPTY":       "",
+		"PLAIN":       "plain",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("maskSecretEnv() = %v, want %v", got, want)
+	}
+}
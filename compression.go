@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+const (
+	compressionGzip  = "gzip"
+	compressionPgzip = "pgzip"
+	compressionZstd  = "zstd"
+)
+
+// pgzipBlockSize is the size of the chunks compressed concurrently by pgzip
+const pgzipBlockSize = 1 << 20
+
+// compressionLevelDefault selects the default level of the compression algorithm
+const compressionLevelDefault = -1
+
+// layerCompression is the compression applied to layers before uploading them
+type layerCompression struct {
+	// Algo is one of gzip, pgzip or zstd
+	Algo string
+
+	// Level is the algorithm specific compression level, or compressionLevelDefault
+	Level int
+}
+
+// newLayerCompression validates the requested algorithm and level.
+// layers are gzip encoded unless zstd is asked for explicitly
+func newLayerCompression(algo string, level int) (layerCompression, error) {
+	switch algo {
+	case compressionGzip, compressionPgzip, compressionZstd:
+	default:
+		return layerCompression{}, fmt.Errorf("unknown compression %q (expected one of gzip, pgzip, zstd)", algo)
+	}
+
+	return layerCompression{Algo: algo, Level: level}, nil
+}
+
+// Encoding is the content encoding of the compressed layer as sent to the server
+func (lc layerCompression) Encoding() string {
+	if lc.Algo == compressionZstd {
+		return compressionZstd
+	}
+	return compressionGzip
+}
+
+// Extension is the file extension used for compressed temp files
+func (lc layerCompression) Extension() string {
+	if lc.Algo == compressionZstd {
+		return ".zst"
+	}
+	return ".gz"
+}
+
+// NewWriter returns a writer compressing into w. The writer must be closed to flush all data.
+func (lc layerCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	switch lc.Algo {
+	case compressionZstd:
+		level := zstd.SpeedDefault
+		if lc.Level != compressionLevelDefault {
+			level = zstd.EncoderLevelFromZstd(lc.Level)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+
+	case compressionPgzip:
+		pw, err := pgzip.NewWriterLevel(w, lc.Level)
+		if err != nil {
+			return nil, err
+		}
+
+		err = pw.SetConcurrency(pgzipBlockSize, runtime.NumCPU())
+		if err != nil {
+			return nil, err
+		}
+
+		return pw, nil
+
+	default:
+		return gzip.NewWriterLevel(w, lc.Level)
+	}
+}
+
+// decompressLayer detects the compression of a stored layer blob and returns the plain tar stream
+func decompressLayer(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+
+	default:
+		return io.NopCloser(br), nil
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestLayerCompressionRoundTrip(t *testing.T) {
+	// larger than a pgzip block, and not compressible to nothing
+	data := make([]byte, 3*pgzipBlockSize+123)
+	rand.New(rand.NewSource(1)).Read(data[:len(data)/2])
+
+	tests := []layerCompression{
+		{Algo: compressionGzip, Level: compressionLevelDefault},
+		{Algo: compressionGzip, Level: 0},
+		{Algo: compressionPgzip, Level: compressionLevelDefault},
+		{Algo: compressionPgzip, Level: 9},
+		{Algo: compressionZstd, Level: compressionLevelDefault},
+		{Algo: compressionZstd, Level: 19},
+	}
+
+	for _, lc := range tests {
+		for _, in := range [][]byte{data, nil} {
+			var compressed bytes.Buffer
+			w, err := lc.NewWriter(&compressed)
+			if err != nil {
+				t.Fatalf("%+v: %v", lc, err)
+			}
+
+			if _, err := w.Write(in); err != nil {
+				t.Fatalf("%+v: %v", lc, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%+v: %v", lc, err)
+			}
+
+			r, err := decompressLayer(&compressed)
+			if err != nil {
+				t.Fatalf("%+v: %v", lc, err)
+			}
+
+			out, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%+v: %v", lc, err)
+			}
+
+			if !bytes.Equal(out, in) {
+				t.Errorf("%+v: got %d bytes back, want %d", lc, len(out), len(in))
+			}
+		}
+	}
+}
+
+func TestLayerCompressionStoreLevel(t *testing.T) {
+	data := bytes.Repeat([]byte("kraud"), 1000)
+
+	var compressed bytes.Buffer
+	w, err := layerCompression{Algo: compressionGzip, Level: 0}.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Close()
+
+	// level 0 stores the data without compressing it
+	if compressed.Len() < len(data) {
+		t.Errorf("level 0 compressed %d bytes to %d", len(data), compressed.Len())
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestLayerCompressionErrors(t *testing.T) {
+	for _, algo := range []string{compressionGzip, compressionPgzip, compressionZstd} {
+		lc := layerCompression{Algo: algo, Level: compressionLevelDefault}
+
+		w, err := lc.NewWriter(failingWriter{})
+		if err != nil {
+			t.Fatalf("%s: %v", algo, err)
+		}
+
+		data := make([]byte, 2*pgzipBlockSize)
+		rand.New(rand.NewSource(1)).Read(data)
+
+		_, werr := w.Write(data)
+		_, werr2 := w.Write(data)
+		cerr := w.Close()
+		if werr == nil && werr2 == nil && cerr == nil {
+			t.Errorf("%s: expected the write error to be returned", algo)
+		}
+	}
+
+	if _, err := (layerCompression{Algo: compressionPgzip, Level: 42}).NewWriter(io.Discard); err == nil {
+		t.Errorf("expected an error for an invalid level")
+	}
+}
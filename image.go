@@ -2,13 +2,12 @@ package main
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"io"
-	"io/fs"
 
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/cobra"
@@ -21,10 +20,10 @@ import (
 
 	"github.com/k0kubun/go-ansi"
 	"github.com/kraudcloud/cli/api"
+	"github.com/kraudcloud/cli/completions"
 	"github.com/kraudcloud/cli/compose"
-	"github.com/mattn/go-isatty"
-	"github.com/mitchellh/colorstring"
-	"github.com/schollz/progressbar/v3"
+	"github.com/kraudcloud/cli/compose/envparser"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	//dockertypes "github.com/docker/docker/api/types"
 	dockerclient "github.com/docker/docker/client"
@@ -38,7 +37,14 @@ func imagesCMD() *cobra.Command {
 	}
 
 	c.AddCommand(imagesLs())
+	c.AddCommand(imagesInspect())
+	c.AddCommand(imagesRm())
+	c.AddCommand(imagesTag())
+	c.AddCommand(imagesHistory())
+	c.AddCommand(imagesSign())
+	c.AddCommand(imagesVerify())
 	c.AddCommand(imagePushCMD())
+	c.AddCommand(imagePullCMD())
 
 	return c
 }
@@ -57,6 +63,11 @@ func imagesLs() *cobra.Command {
 
 			table := NewTable("AID", "Size", "Name")
 			for _, i := range ls.Items {
+				// signatures are shown by images verify
+				if isImageSignatureRef(i.Ref) {
+					continue
+				}
+
 				if i.Amd64 == nil {
 					table.AddRow(
 						i.AID,
@@ -79,27 +90,426 @@ func imagesLs() *cobra.Command {
 	return c
 }
 
-type extractedFileInfo struct {
-	hash           string
-	tempfile       string
-	zippedtempfile string
-	size           int64
+type imageInspectView struct {
+	AID   string
+	ID    string
+	Ref   string
+	Amd64 *imageArchView `json:",omitempty"`
+}
+
+type imageArchView struct {
+	ID     string
+	OciID  string
+	Size   uint64
+	Config json.RawMessage
+	Layers []imageLayerView
+}
+
+type imageLayerView struct {
+	ID    string `json:",omitempty"`
+	OciID string `json:",omitempty"`
+	Size  uint64
+	Lost  bool `json:",omitempty"`
+}
+
+func imagesInspect() *cobra.Command {
+	c := &cobra.Command{
+		Use:     "inspect <image>",
+		Short:   "Inspect a remote image",
+		Aliases: []string{"i", "show"},
+		Args:    cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			img, err := API().InspectImage(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting image: %v\n", err)
+				return nil
+			}
+
+			layers, err := API().ListLayers(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+				return nil
+			}
+
+			view := imageInspectView{
+				AID: img.AID,
+				ID:  img.ID,
+				Ref: img.Ref,
+			}
+
+			if img.Amd64 != nil {
+				view.Amd64 = &imageArchView{
+					ID:     img.Amd64.ID,
+					OciID:  img.Amd64.OciID,
+					Size:   img.Amd64.Size,
+					Config: json.RawMessage(img.Amd64.Config),
+				}
+
+				// the config is stored as a string, keep it readable if it is json
+				if !json.Valid(view.Amd64.Config) {
+					view.Amd64.Config, _ = json.Marshal(img.Amd64.Config)
+				}
+
+				for _, ref := range img.Amd64.Layers {
+					lv := imageLayerView{}
+					if ref.ID != nil {
+						lv.ID = *ref.ID
+					}
+					if ref.OciID != nil {
+						lv.OciID = *ref.OciID
+					}
+
+					if l := findLayer(layers, ref); l != nil {
+						lv.ID = l.ID
+						lv.OciID = l.OciID
+						lv.Size = l.Size
+						lv.Lost = l.Lost
+					}
+
+					view.Amd64.Layers = append(view.Amd64.Layers, lv)
+				}
+			}
+
+			return identJSONEncoder(cmd.OutOrStdout(), view)
+		},
+	}
+
+	return c
 }
 
-func imageExtractFromDocker(ctx context.Context, serviceName string, ref string) (map[string]*extractedFileInfo, error) {
+func imagesRm() *cobra.Command {
+	force := false
+
+	c := &cobra.Command{
+		Use:     "rm <image>",
+		Short:   "Remove a remote image",
+		Aliases: []string{"remove", "del", "delete"},
+		Args:    cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			img, err := API().InspectImage(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting image: %v\n", err)
+				return nil
+			}
+
+			pods, err := API().ListPods(cmd.Context(), false)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing pods: %v\n", err)
+				return nil
+			}
+
+			var users []string
+			for _, p := range pods.Items {
+				for _, c := range p.Containers {
+					if compose.NormalizeImageRef(c.ImageName) == compose.NormalizeImageRef(img.Ref) {
+						users = append(users, p.Namespace+"/"+p.Name)
+						break
+					}
+				}
+			}
+
+			if len(users) > 0 && !force {
+				fmt.Fprintf(cmd.ErrOrStderr(), "image %s is used by %s. use --force to delete it anyway\n", img.Ref, strings.Join(users, ", "))
+				return nil
+			}
+
+			images, err := API().ListImages(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing images: %v\n", err)
+				return nil
+			}
+
+			// other names pointing to the same content keep the layers alive,
+			// and other tags of the same repository keep its signatures
+			var aliases []string
+			signed := img.Amd64 != nil
+			if img.Amd64 != nil {
+				for _, i := range images.Items {
+					if i.AID != img.AID && i.Amd64 != nil && i.Amd64.OciID == img.Amd64.OciID {
+						aliases = append(aliases, i.Ref)
+						if imageRepository(i.Ref) == imageRepository(img.Ref) {
+							signed = false
+						}
+					}
+				}
+			}
+
+			layers, err := API().ListLayers(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+				return nil
+			}
+
+			var signatureRef string
+			if signed {
+				if digest, err := contentDigest(img.Amd64, layers); err == nil {
+					signatureRef = imageSignatureRef(img.Ref, digest)
+				}
+			}
+
+			err = API().DeleteImage(cmd.Context(), img.Ref)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error deleting image: %v\n", err)
+				return nil
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "deleted %s\n", img.Ref)
+
+			for _, i := range images.Items {
+				if signatureRef == "" || i.Ref != signatureRef {
+					continue
+				}
 
-	var barProxy io.Writer
+				err = API().DeleteImage(cmd.Context(), i.Ref)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error deleting signatures %s: %v\n", i.Ref, err)
+					return nil
+				}
+
+				fmt.Fprintf(cmd.OutOrStdout(), "deleted %s\n", i.Ref)
+			}
 
-	if isatty.IsTerminal(os.Stdout.Fd()) {
+			if len(aliases) > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "image content is still referenced by %s\n", strings.Join(aliases, ", "))
+				return nil
+			}
 
-		bar := NewBar(100, "[cyan]"+serviceName+"[reset] Extracting "+ref+" from docker")
-		defer bar.Finish()
+			if img.Amd64 == nil {
+				return nil
+			}
 
-		barProxy = bar
-	} else {
-		colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Extracting "+ref+" from docker")
+			unreferenced, size := 0, uint64(0)
+			for _, ref := range img.Amd64.Layers {
+				if l := findLayer(layers, ref); l != nil && l.Refcount <= 1 {
+					unreferenced++
+					size += l.Size
+				}
+			}
+
+			if unreferenced > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d layers (%s) are no longer referenced\n", unreferenced, humanize.Bytes(size))
+			}
+
+			return nil
+		},
 	}
 
+	c.Flags().BoolVar(&force, "force", false, "delete the image even if pods are using it")
+
+	return c
+}
+
+func imagesTag() *cobra.Command {
+	c := &cobra.Command{
+		Use:   "tag <source> <target>",
+		Short: "Add a reference to an existing remote image",
+		Args:  cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			img, err := API().InspectImage(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting image: %v\n", err)
+				return nil
+			}
+
+			if img.Amd64 == nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "image %s has no amd64 variant\n", img.Ref)
+				return nil
+			}
+
+			rsp, err := imageAddRef(cmd.Context(), img, args[1])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error tagging image: %v\n", err)
+				return nil
+			}
+
+			for _, rn := range rsp.Renamed {
+				fmt.Fprintf(cmd.ErrOrStderr(), "renamed existing image to %s\n", rn.Ref)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), rsp.Created.AID)
+			return nil
+		},
+	}
+
+	return c
+}
+
+func imagesHistory() *cobra.Command {
+	noTrunc := false
+
+	c := &cobra.Command{
+		Use:   "history <image>",
+		Short: "Show the layer history of a remote image",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			img, err := API().InspectImage(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting image: %v\n", err)
+				return nil
+			}
+
+			if img.Amd64 == nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "image %s has no amd64 variant\n", img.Ref)
+				return nil
+			}
+
+			layers, err := API().ListLayers(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+				return nil
+			}
+
+			// the stored config is either a full oci image config,
+			// or only its runtime config section
+			var config ocispec.Image
+			if err := json.Unmarshal([]byte(img.Amd64.Config), &config); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error decoding image config: %v\n", err)
+				return nil
+			}
+			if len(config.History) == 0 {
+				json.Unmarshal([]byte(img.Amd64.Config), &config.Config)
+			}
+
+			trunc := func(s string, n int) string {
+				if noTrunc || len(s) <= n {
+					return s
+				}
+				return s[:n-2] + ".."
+			}
+
+			type row struct {
+				layer, created, createdBy, size, comment string
+			}
+
+			var rows []row
+			li := 0
+			nextLayer := func() (string, string) {
+				if li >= len(img.Amd64.Layers) {
+					return "<missing>", "?"
+				}
+
+				ref := img.Amd64.Layers[li]
+				li++
+
+				l := findLayer(layers, ref)
+				if l == nil {
+					return "<missing>", "?"
+				}
+
+				return trunc(l.OciID, 19), humanize.Bytes(l.Size)
+			}
+
+			if len(config.History) > 0 {
+				for _, h := range config.History {
+					r := row{
+						createdBy: trunc(strings.TrimPrefix(h.CreatedBy, "/bin/sh -c "), 60),
+						size:      "0 B",
+						comment:   h.Comment,
+					}
+
+					if h.Created != nil {
+						r.created = humanize.Time(*h.Created)
+					}
+
+					if !h.EmptyLayer {
+						r.layer, r.size = nextLayer()
+					}
+
+					rows = append(rows, r)
+				}
+			} else {
+				for range img.Amd64.Layers {
+					r := row{}
+					r.layer, r.size = nextLayer()
+					rows = append(rows, r)
+				}
+
+				if len(config.Config.Entrypoint) > 0 {
+					rows = append(rows, row{createdBy: trunc(fmt.Sprintf("ENTRYPOINT %q", config.Config.Entrypoint), 60), size: "0 B"})
+				}
+				if len(config.Config.Cmd) > 0 {
+					rows = append(rows, row{createdBy: trunc(fmt.Sprintf("CMD %q", config.Config.Cmd), 60), size: "0 B"})
+				}
+			}
+
+			table := NewTable("Layer", "Created", "Created By", "Size", "Comment")
+			for i := len(rows) - 1; i >= 0; i-- {
+				table.AddRow(rows[i].layer, rows[i].created, rows[i].createdBy, rows[i].size, rows[i].comment)
+			}
+			table.Print()
+
+			return nil
+		},
+	}
+
+	c.Flags().BoolVar(&noTrunc, "no-trunc", false, "Don't truncate output")
+
+	return c
+}
+
+// imageAddRef creates a new reference to the content of an existing image.
+// the layers are already uploaded, so nothing is transferred
+func imageAddRef(ctx context.Context, img *api.KraudImageName, ref string) (*api.KraudCreateImageResponse, error) {
+	return API().CreateImage(ctx, api.CreateImageJSONBody{
+		Ref:          ref,
+		Config:       img.Amd64.Config,
+		OciID:        img.Amd64.OciID,
+		Architecture: "amd64",
+		Layers:       img.Amd64.Layers,
+	})
+}
+
+// findLayer returns the layer a reference points to, by ID or OciID
+func findLayer(layers *api.KraudLayerList, ref api.KraudLayerReference) *api.KraudLayer {
+	for i, l := range layers.Items {
+		if ref.ID != nil && *ref.ID == l.ID {
+			return &layers.Items[i]
+		}
+		if ref.OciID != nil && *ref.OciID == l.OciID {
+			return &layers.Items[i]
+		}
+	}
+
+	return nil
+}
+
+// imageRepository strips the tag and digest from an image ref
+func imageRepository(ref string) string {
+	if i := strings.Index(ref, "@"); i != -1 {
+		ref = ref[:i]
+	}
+
+	if i := strings.LastIndex(ref, ":"); i != -1 && !strings.Contains(ref[i:], "/") {
+		ref = ref[:i]
+	}
+
+	return ref
+}
+
+type extractedFileInfo struct {
+	hash           string
+	tempfile       string
+	zippedtempfile string
+	size           int64
+
+	// existed is set when the kraud already had the layer and refused the upload
+	existed bool
+}
+
+func imageExtractFromDocker(ctx context.Context, p progressReporter, serviceName string, ref string) (map[string]*extractedFileInfo, error) {
+
 	docker, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -111,17 +521,20 @@ func imageExtractFromDocker(ctx context.Context, serviceName string, ref string)
 		return nil, err
 	}
 
+	ev := progressEvent{Phase: "extract", Service: serviceName, Ref: ref, Total: img.Size}
+	p.Event(withStatus(ev, statusStart, "Extracting "+ref+" from docker"))
+	defer p.Event(withStatus(ev, statusDone, ""))
+
 	imgtar, err := docker.ImageSave(ctx, []string{img.ID})
 	if err != nil {
 		return nil, err
 	}
 	defer imgtar.Close()
 
-	var reader io.Reader = imgtar
-	if barProxy != nil {
-		reader = io.TeeReader(reader, barProxy)
-	}
-	tr := tar.NewReader(reader)
+	counter := newProgressCounter(p, ev)
+	defer counter.Flush()
+
+	tr := tar.NewReader(io.TeeReader(imgtar, counter))
 
 	var tmpfiles = make(map[string]*extractedFileInfo)
 
@@ -131,12 +544,12 @@ func imageExtractFromDocker(ctx context.Context, serviceName string, ref string)
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return tmpfiles, err
 		}
 
 		file, err := os.CreateTemp("", "dockersave")
 		if err != nil {
-			panic(err)
+			return tmpfiles, err
 		}
 		defer file.Close()
 
@@ -145,7 +558,7 @@ func imageExtractFromDocker(ctx context.Context, serviceName string, ref string)
 
 		size, err := io.Copy(w, tr)
 		if err != nil {
-			return nil, err
+			return tmpfiles, err
 		}
 
 		tmpfiles[h.Name] = &extractedFileInfo{
@@ -158,21 +571,26 @@ func imageExtractFromDocker(ctx context.Context, serviceName string, ref string)
 	return tmpfiles, nil
 }
 
-func zipLayers(serviceName string, r map[string]*extractedFileInfo) error {
+// withStatus returns a copy of ev with the given status and message
+func withStatus(ev progressEvent, status string, message string) progressEvent {
+	ev.Status = status
+	ev.Message = message
+	return ev
+}
+
+func zipLayers(p progressReporter, serviceName string, r map[string]*extractedFileInfo, lc layerCompression) error {
 	total := int64(0)
 	for _, v := range r {
 		total += v.size
 	}
 
-	var bar *progressbar.ProgressBar
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		bar = NewBar(int(total), "[cyan]"+serviceName+"[reset] Compressing layers ")
-		defer bar.Finish()
-	} else {
-		colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Compressing layers")
-	}
+	ev := progressEvent{Phase: "compress", Service: serviceName, Total: total}
+	p.Event(withStatus(ev, statusStart, "Compressing layers"))
+	defer p.Event(withStatus(ev, statusDone, ""))
 
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
 
 	for _, v := range r {
 
@@ -182,67 +600,84 @@ func zipLayers(serviceName string, r map[string]*extractedFileInfo) error {
 
 			defer wg.Done()
 
-			layertar, err := os.Open(v.tempfile)
+			err := zipLayer(p, serviceName, v, lc)
 			if err != nil {
-				panic(err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("compressing layer sha256:%s: %w", v.hash, err))
+				mu.Unlock()
 			}
-			defer layertar.Close()
+		}(v)
 
-			var reader io.Reader = layertar
-			if bar != nil {
-				reader = io.TeeReader(reader, bar)
-			}
+	}
 
-			zipped, err := os.Create(v.tempfile + ".gz")
-			if err != nil {
-				panic(err)
-			}
+	wg.Wait()
 
-			gz := gzip.NewWriter(zipped)
-			defer gz.Close()
+	return errors.Join(errs...)
 
-			_, err = io.Copy(gz, reader)
-			if err != nil {
-				panic(err)
-			}
+}
 
-			err = gz.Close()
-			if err != nil {
-				panic(err)
-			}
+func zipLayer(p progressReporter, serviceName string, v *extractedFileInfo, lc layerCompression) error {
+	layertar, err := os.Open(v.tempfile)
+	if err != nil {
+		return err
+	}
+	defer layertar.Close()
 
-			err = zipped.Close()
-			if err != nil {
-				panic(err)
-			}
+	counter := newProgressCounter(p, progressEvent{Phase: "compress", Service: serviceName, Layer: "sha256:" + v.hash, Total: v.size})
+	defer counter.Flush()
 
-			v.zippedtempfile = zipped.Name()
-		}(v)
+	zipped, err := os.Create(v.tempfile + lc.Extension())
+	if err != nil {
+		return err
+	}
+	defer zipped.Close()
 
+	zw, err := lc.NewWriter(zipped)
+	if err != nil {
+		return err
 	}
+	defer zw.Close()
 
-	wg.Wait()
+	_, err = io.Copy(zw, io.TeeReader(layertar, counter))
+	if err != nil {
+		return err
+	}
 
-	return nil
+	err = zw.Close()
+	if err != nil {
+		return err
+	}
+
+	err = zipped.Close()
+	if err != nil {
+		return err
+	}
 
+	v.zippedtempfile = zipped.Name()
+	return nil
 }
 
-func uploadLayers(serviceName string, r map[string]*extractedFileInfo) error {
+func uploadLayers(p progressReporter, serviceName string, r map[string]*extractedFileInfo, lc layerCompression) error {
 
+	var sizes = make(map[*extractedFileInfo]int64)
 	total := int64(0)
 	for _, v := range r {
-		total += v.size
-	}
+		stat, err := os.Stat(v.zippedtempfile)
+		if err != nil {
+			return err
+		}
 
-	var bar *progressbar.ProgressBar
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		bar = NewBar(int(total), "[cyan]"+serviceName+"[reset] Uploading layers ")
-		defer bar.Finish()
-	} else {
-		colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Uploading layers")
+		sizes[v] = stat.Size()
+		total += stat.Size()
 	}
 
+	ev := progressEvent{Phase: "upload", Service: serviceName, Total: total}
+	p.Event(withStatus(ev, statusStart, "Uploading layers"))
+	defer p.Event(withStatus(ev, statusDone, ""))
+
 	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
 
 	for _, v := range r {
 
@@ -252,225 +687,281 @@ func uploadLayers(serviceName string, r map[string]*extractedFileInfo) error {
 
 			defer wg.Done()
 
-			layertargz, err := os.Open(v.zippedtempfile)
+			err := uploadLayer(p, serviceName, v, sizes[v], lc)
 			if err != nil {
-				panic(err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("uploading layer sha256:%s: %w", v.hash, err))
+				mu.Unlock()
 			}
-			defer layertargz.Close()
+		}(v)
 
-			var reader io.Reader = layertargz
-			if bar != nil {
-				reader = io.TeeReader(reader, bar)
-			}
+	}
 
-			stat, err := layertargz.Stat()
-			if err != nil {
-				panic(err)
-			}
+	wg.Wait()
 
-			_, err = API().PushLayer(context.Background(),
-				"sha256:"+v.hash,
-				reader,
-				uint64(stat.Size()),
-			)
-			if err != nil {
+	return errors.Join(errs...)
+}
 
-				if strings.Contains(err.Error(), "Conflict") {
-					return
-				}
+func uploadLayer(p progressReporter, serviceName string, v *extractedFileInfo, size int64, lc layerCompression) error {
+	layertargz, err := os.Open(v.zippedtempfile)
+	if err != nil {
+		return err
+	}
+	defer layertargz.Close()
 
-				fmt.Println()
-				panic(err)
-			}
+	ev := progressEvent{Phase: "upload", Service: serviceName, Layer: "sha256:" + v.hash, Total: size}
 
-		}(v)
+	counter := newProgressCounter(p, ev)
+	defer counter.Flush()
 
-	}
+	_, err = API().PushLayer(context.Background(),
+		"sha256:"+v.hash,
+		io.TeeReader(layertargz, counter),
+		uint64(size),
+		lc.Encoding(),
+	)
+	if err != nil {
 
-	wg.Wait()
+		if strings.Contains(err.Error(), "Conflict") {
+			// count the layer as done so the totals add up
+			counter.ev.Done = size
+			v.existed = true
+			ev.Skipped = true
+			p.Event(withStatus(ev, statusSkipped, "layer already exists"))
+			return nil
+		}
+
+		return err
+	}
 
 	return nil
 }
 
-func imagePushCMD() *cobra.Command {
-	const defaultComposeFile = "docker-compose.yml"
-	var composeFile string
-	var pushAnyway bool
+// imagePush uploads a local docker image, unless the remote image is already up to date
+func imagePush(ctx context.Context, docker *dockerclient.Client, p progressReporter, serviceName string, ref string, lc layerCompression, pushAnyway bool) (*api.KraudImageName, error) {
+	ev := progressEvent{Phase: "analyze", Service: serviceName, Ref: ref}
+	p.Event(withStatus(ev, statusStart, "Analyzing image "+ref))
 
-	c := &cobra.Command{
-		Use:   "push [IMAGE ...]",
-		Short: "Push local images to the kraud",
-		PreRun: func(cmd *cobra.Command, args []string) {
-			if composeFile != defaultComposeFile {
-				return
-			}
+	// first get the state of the remote image
+	remoteImage, _ := API().InspectImage(ctx, ref)
 
-			if _, err := os.Stat(composeFile); errors.Is(err, fs.ErrNotExist) {
-				composeFile = "docker-compose.yaml"
-			}
-		},
-		Run: func(cmd *cobra.Command, args []string) {
-			var images = make(map[string]string)
+	// then get the state of the local image
+	localImage, _, _ := docker.ImageInspectWithRaw(ctx, ref)
 
-			if len(args) > 0 {
+	// if both exist and are valid, do nothing
+	if remoteImage != nil && remoteImage.Amd64 != nil && localImage.ID != "" && !pushAnyway {
+		if localImage.ID == remoteImage.Amd64.OciID {
+			ev.Skipped = true
+			ev.AID = remoteImage.AID
+			p.Event(withStatus(ev, statusSkipped, "Remote image is up to date"))
+			return remoteImage, nil
+		}
+	}
 
-				for _, arg := range args {
-					images[arg] = arg
-				}
+	// if only the remote exists, do nothing
+	if remoteImage != nil && localImage.ID == "" {
+		ev.Skipped = true
+		ev.AID = remoteImage.AID
+		p.Event(withStatus(ev, statusSkipped, "Image "+ref+" not available locally!"))
+		return remoteImage, nil
+	}
 
-			} else {
-				spec, err := compose.ParseFile(composeFile)
-				if err != nil {
-					panic(err)
-				}
-				for serviceName, s := range spec.Services {
-					images[s.Image] = serviceName
-				}
-			}
+	files, err := imageExtractFromDocker(ctx, p, serviceName, ref)
 
-			i := 0
-			for ref, serviceName := range images {
-				if i > 0 {
-					fmt.Println()
-				}
-				i++
+	defer func() {
+		for _, t := range files {
+			os.Remove(t.tempfile)
+			os.Remove(t.zippedtempfile)
+		}
+	}()
 
-				colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Analyzing image "+ref)
+	if err != nil {
+		return nil, err
+	}
 
-				docker, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
-				if err != nil {
-					panic(err)
-				}
-				defer docker.Close()
+	if files["manifest.json"] == nil {
+		return nil, fmt.Errorf("manifest.json not found")
+	}
 
-				// first get the state of the remote image
-				remoteImage, _ := API().InspectImage(cmd.Context(), ref)
+	var manifest []struct {
+		Config string
+		Layers []string
+	}
 
-				// then get the state of the local image
-				localImage, _, _ := docker.ImageInspectWithRaw(cmd.Context(), ref)
+	manifestFile, err := os.Open(files["manifest.json"].tempfile)
+	if err != nil {
+		return nil, err
+	}
+	defer manifestFile.Close()
 
-				// if both exist and are valid, do nothing
-				if remoteImage != nil && localImage.ID != "" && !pushAnyway {
-					if localImage.ID == remoteImage.Amd64.OciID {
-						colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Remote image is up to date")
-						fmt.Println(remoteImage.AID)
-						continue
-					}
-				}
+	if err := json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
+		return nil, err
+	}
 
-				// if only the remote exists, do nothing
-				if remoteImage != nil && localImage.ID == "" {
-					colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Image "+ref+" not available locally!")
-					fmt.Println(remoteImage.AID)
-					continue
-				}
+	if len(manifest) == 0 || files[manifest[0].Config] == nil {
+		return nil, fmt.Errorf("image config not found")
+	}
 
-				files, err := imageExtractFromDocker(cmd.Context(), serviceName, ref)
+	var config struct {
+		Architecture string          `json:"architecture"`
+		Config       json.RawMessage `json:"config"`
+		Rootfs       struct {
+			Type    string   `json:"type"`
+			DiffIDs []string `json:"diff_ids"`
+		} `json:"rootfs"`
+	}
 
-				defer func() {
-					for _, t := range files {
-						os.Remove(t.tempfile)
-						os.Remove(t.zippedtempfile)
-					}
-				}()
+	configString, err := os.ReadFile(files[manifest[0].Config].tempfile)
+	if err != nil {
+		return nil, err
+	}
 
-				if err != nil {
-					panic(err)
-				}
+	if err := json.Unmarshal(configString, &config); err != nil {
+		return nil, err
+	}
 
-				if files["manifest.json"] == nil {
-					panic("manifest.json not found")
-				}
+	ociid := "sha256:" + files[manifest[0].Config].hash
 
-				var manifest []struct {
-					Config string
-					Layers []string
-				}
+	layers := make(map[string]*extractedFileInfo)
+	for _, m := range manifest {
+		for _, l := range m.Layers {
+			layers[l] = files[l]
+			if files[l] == nil {
+				return nil, fmt.Errorf("layer missing %s", l)
+			}
+		}
+	}
 
-				manifestFile, err := os.Open(files["manifest.json"].tempfile)
-				if err != nil {
-					panic(err)
-				}
+	err = zipLayers(p, serviceName, layers, lc)
+	if err != nil {
+		return nil, err
+	}
 
-				if err := json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
-					panic(err)
-				}
+	err = uploadLayers(p, serviceName, layers, lc)
+	if err != nil {
+		return nil, err
+	}
 
-				var config struct {
-					Architecture string          `json:"architecture"`
-					Config       json.RawMessage `json:"config"`
-					Rootfs       struct {
-						Type    string   `json:"type"`
-						DiffIDs []string `json:"diff_ids"`
-					} `json:"rootfs"`
-				}
+	ev = progressEvent{Phase: "create", Service: serviceName, Ref: ref}
+	p.Event(withStatus(ev, statusStart, "Creating references"))
 
-				configString, err := os.ReadFile(files[manifest[0].Config].tempfile)
-				if err != nil {
-					panic(err)
-				}
+	layerRefs := []api.KraudLayerReference{}
+	for _, diffID := range config.Rootfs.DiffIDs {
+		var diffID = diffID
+		layerRefs = append(layerRefs, api.KraudLayerReference{
+			OciID: &diffID,
+		})
+	}
 
-				if err := json.Unmarshal(configString, &config); err != nil {
-					panic(err)
-				}
+	rsp, err := API().CreateImage(ctx, api.CreateImageJSONBody{
+		Ref:          ref,
+		Config:       string(config.Config),
+		OciID:        ociid,
+		Architecture: runtime.GOARCH,
+		Layers:       layerRefs,
+	})
 
-				ociid := "sha256:" + files[manifest[0].Config].hash
+	if err != nil {
+		return nil, err
+	}
 
-				layers := make(map[string]*extractedFileInfo)
-				for _, m := range manifest {
-					for _, l := range m.Layers {
-						layers[l] = files[l]
-						if files[l] == nil {
-							panic(fmt.Sprintf("layer missing %s", l))
-						}
-					}
+	for _, rn := range rsp.Renamed {
+		p.Event(withStatus(ev, statusInfo, "Renamed existing image to "+rn.Ref))
+	}
+
+	ev.AID = rsp.Created.AID
+	p.Event(withStatus(ev, statusDone, ""))
+
+	return rsp.Created, nil
+}
+
+func imagePushCMD() *cobra.Command {
+	var composeFiles []string
+	var pushAnyway bool
+	var compression string
+	compressionLevel := compressionLevelDefault
+	var progressMode string
+	env := map[string]string{}
+	envFile := ".env"
+	envSources := []string{}
+
+	c := &cobra.Command{
+		Use:   "push [IMAGE ...]",
+		Short: "Push local images to the kraud",
+		Run: func(cmd *cobra.Command, args []string) {
+			p, err := newProgress(progressMode, cmd.OutOrStdout(), ansi.NewAnsiStderr())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+
+			fail := func(serviceName string, err error) {
+				p.Event(progressEvent{Phase: "push", Status: statusError, Service: serviceName, Error: err.Error()})
+				os.Exit(1)
+			}
+
+			lc, err := newLayerCompression(compression, compressionLevel)
+			if err != nil {
+				fail("", err)
+			}
+
+			var images = make(map[string]string)
+
+			if len(args) > 0 {
+
+				for _, arg := range args {
+					images[arg] = arg
 				}
 
-				err = zipLayers(serviceName, layers)
+			} else {
+				_, template, err := loadComposeTemplate(composeFiles)
 				if err != nil {
-					panic(err)
+					fail("", err)
 				}
 
-				err = uploadLayers(serviceName, layers)
+				_, loaded, err := loadComposeEnv(cmd.ErrOrStderr(), template, env, envFile, envSources, false)
 				if err != nil {
-					panic(err)
+					fail("", err)
 				}
 
-				colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Creating references")
-
-				layerRefs := []api.KraudLayerReference{}
-				for _, diffID := range config.Rootfs.DiffIDs {
-					var diffID = diffID
-					layerRefs = append(layerRefs, api.KraudLayerReference{
-						OciID: &diffID,
-					})
+				interpolated, err := envparser.Interpolate(template, loaded.Values())
+				if err != nil {
+					fail("", err)
 				}
 
-				rsp, err := API().CreateImage(context.Background(), api.CreateImageJSONBody{
-					Ref:          ref,
-					Config:       string(config.Config),
-					OciID:        ociid,
-					Architecture: runtime.GOARCH,
-					Layers:       layerRefs,
-				})
-
+				spec, err := compose.Parse(bytes.NewReader(interpolated))
 				if err != nil {
-					panic(err)
+					fail("", err)
 				}
-
-				for _, rn := range rsp.Renamed {
-					colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+serviceName+"[reset] Renamed existing image to "+rn.Ref)
+				for serviceName, s := range spec.Services {
+					images[s.Image] = serviceName
 				}
+			}
 
-				fmt.Println(rsp.Created.AID)
+			docker, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+			if err != nil {
+				fail("", err)
+			}
+			defer docker.Close()
 
+			for ref, serviceName := range images {
+				_, err := imagePush(cmd.Context(), docker, p, serviceName, ref, lc, pushAnyway)
+				if err != nil {
+					fail(serviceName, err)
+				}
 			}
 
 		},
 	}
 
-	c.Flags().StringVarP(&composeFile, "compose-file", "f", defaultComposeFile, "Compose file")
+	c.Flags().StringSliceVarP(&composeFiles, "compose-file", "f", composeFiles, "Compose files, later files override earlier ones")
+	c.Flags().StringToStringVarP(&env, "env", "e", env, "set environment variables of the compose files")
+	c.Flags().StringVar(&envFile, "env-file", envFile, "set environment variables of the compose files from a file")
+	c.Flags().StringArrayVar(&envSources, "env-source", envSources, envSourceUsage)
 	c.Flags().BoolVar(&pushAnyway, "push-always", false, "Push anyway even if remote says its up to date")
+	c.Flags().StringVar(&compression, "compression", compressionPgzip, "Layer compression (gzip, pgzip, zstd), zstd needs a server that accepts zstd encoded layers")
+	c.Flags().IntVar(&compressionLevel, "compression-level", compressionLevel, "Compression level of the selected algorithm (-1 for default)")
+	c.Flags().StringVar(&progressMode, "progress", progressAuto, "Progress output (auto, tty, plain, json)")
 
 	return c
 }
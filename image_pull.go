@@ -0,0 +1,438 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/k0kubun/go-ansi"
+	"github.com/kraudcloud/cli/api"
+	"github.com/kraudcloud/cli/completions"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/colorstring"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+
+	dockerclient "github.com/docker/docker/client"
+)
+
+const (
+	pullFormatDocker = "docker-archive"
+	pullFormatOCI    = "oci"
+)
+
+// pulledLayer is an uncompressed layer tar downloaded to a temp file
+type pulledLayer struct {
+	diffID   string
+	tempfile string
+	size     int64
+}
+
+func imagePullCMD() *cobra.Command {
+	save := ""
+	format := pullFormatDocker
+
+	c := &cobra.Command{
+		Use:   "pull <image>",
+		Short: "Pull a remote image into the local docker daemon or a tarball",
+		Long: `Pull a remote image into the local docker daemon or a tarball.
+
+Without --save the image is loaded into the local docker daemon.
+If no daemon is reachable, the image is written to <image>.tar instead.`,
+		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			if format != pullFormatDocker && format != pullFormatOCI {
+				fmt.Fprintf(cmd.ErrOrStderr(), "unknown format %q (expected %s or %s)\n", format, pullFormatDocker, pullFormatOCI)
+				return nil
+			}
+
+			img, err := API().InspectImage(ctx, args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting image: %v\n", err)
+				return nil
+			}
+
+			if img.Amd64 == nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "image %s has no amd64 variant\n", img.Ref)
+				return nil
+			}
+
+			var docker *dockerclient.Client
+			if save == "" {
+				docker, err = dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+				if err == nil {
+					defer docker.Close()
+					_, err = docker.Ping(ctx)
+				}
+
+				if err != nil {
+					docker = nil
+					save = strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(img.Ref) + ".tar"
+					colorstring.Fprintln(ansi.NewAnsiStderr(), "[yellow]docker is not available, saving to "+save+"[reset]")
+				}
+			}
+
+			layers, err := imageDownloadLayers(ctx, img)
+			defer func() {
+				for _, l := range layers {
+					os.Remove(l.tempfile)
+				}
+			}()
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error downloading layers: %v\n", err)
+				return nil
+			}
+
+			config, err := imagePullConfig(img, layers)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error building image config: %v\n", err)
+				return nil
+			}
+
+			if docker == nil {
+				f, err := os.Create(save)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error creating %s: %v\n", save, err)
+					return nil
+				}
+				defer f.Close()
+
+				if format == pullFormatOCI {
+					err = writeOCILayout(f, img.Ref, config, layers)
+				} else {
+					err = writeDockerArchive(f, img.Ref, config, layers)
+				}
+				if err == nil {
+					err = f.Close()
+				}
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error writing %s: %v\n", save, err)
+					return nil
+				}
+
+				fmt.Fprintln(cmd.OutOrStdout(), save)
+				return nil
+			}
+
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(writeDockerArchive(pw, img.Ref, config, layers))
+			}()
+
+			rsp, err := docker.ImageLoad(ctx, pr, false)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error loading image into docker: %v\n", err)
+				return nil
+			}
+			defer rsp.Body.Close()
+
+			err = jsonmessage.DisplayJSONMessagesStream(rsp.Body, cmd.OutOrStdout(), os.Stdout.Fd(), isatty.IsTerminal(os.Stdout.Fd()), nil)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error loading image into docker: %v\n", err)
+				return nil
+			}
+
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&save, "save", save, "write the image to this tarball instead of loading it into docker")
+	c.Flags().StringVar(&format, "format", format, "tarball format (docker-archive, oci)")
+
+	return c
+}
+
+// imageDownloadLayers downloads and decompresses all layers of an image in rootfs order,
+// verifying each against its diff id
+func imageDownloadLayers(ctx context.Context, img *api.KraudImageName) ([]*pulledLayer, error) {
+	all, err := API().ListLayers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []*api.KraudLayer
+	total := uint64(0)
+	for _, ref := range img.Amd64.Layers {
+		l := findLayer(all, ref)
+		if l == nil {
+			return nil, fmt.Errorf("layer %s not found", api.MustJSONString(ref))
+		}
+		if l.Lost {
+			return nil, fmt.Errorf("layer %s is lost", l.OciID)
+		}
+
+		stored = append(stored, l)
+		total += l.Size
+	}
+
+	var bar *progressbar.ProgressBar
+	if isatty.IsTerminal(os.Stdout.Fd()) {
+		bar = NewBar(int(total), "[cyan]"+img.Ref+"[reset] Downloading layers ")
+		defer bar.Finish()
+	} else {
+		colorstring.Fprintln(ansi.NewAnsiStderr(), "[cyan]"+img.Ref+"[reset] Downloading layers")
+	}
+
+	pulled := make([]*pulledLayer, len(stored))
+	errs := make([]error, len(stored))
+
+	var wg sync.WaitGroup
+	for i, l := range stored {
+		wg.Add(1)
+
+		go func(i int, l *api.KraudLayer) {
+			defer wg.Done()
+			pulled[i], errs[i] = imageDownloadLayer(ctx, l, bar)
+		}(i, l)
+	}
+
+	wg.Wait()
+
+	var out []*pulledLayer
+	for _, p := range pulled {
+		if p != nil {
+			out = append(out, p)
+		}
+	}
+
+	return out, errors.Join(errs...)
+}
+
+func imageDownloadLayer(ctx context.Context, l *api.KraudLayer, bar *progressbar.ProgressBar) (*pulledLayer, error) {
+	blob, err := API().DownloadLayer(ctx, l.ID)
+	if err != nil {
+		return nil, fmt.Errorf("layer %s: %w", l.OciID, err)
+	}
+	defer blob.Close()
+
+	var reader io.Reader = blob
+	if bar != nil {
+		reader = io.TeeReader(reader, bar)
+	}
+
+	layertar, err := decompressLayer(reader)
+	if err != nil {
+		return nil, fmt.Errorf("layer %s: %w", l.OciID, err)
+	}
+	defer layertar.Close()
+
+	file, err := os.CreateTemp("", "kralayer")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := &pulledLayer{
+		diffID:   l.OciID,
+		tempfile: file.Name(),
+	}
+
+	hasher := sha256.New()
+	p.size, err = io.Copy(io.MultiWriter(file, hasher), layertar)
+	if err != nil {
+		return p, fmt.Errorf("layer %s: %w", l.OciID, err)
+	}
+
+	if sum := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); sum != l.OciID {
+		return p, fmt.Errorf("layer %s: content digest mismatch, got %s", l.OciID, sum)
+	}
+
+	return p, file.Close()
+}
+
+// imagePullConfig returns the full oci image config for a remote image.
+// images pushed with kra only store the runtime config, so the rest is rebuilt
+func imagePullConfig(img *api.KraudImageName, layers []*pulledLayer) ([]byte, error) {
+	var full ocispec.Image
+	if err := json.Unmarshal([]byte(img.Amd64.Config), &full); err != nil {
+		return nil, err
+	}
+
+	if len(full.RootFS.DiffIDs) > 0 {
+		return []byte(img.Amd64.Config), nil
+	}
+
+	full = ocispec.Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS: ocispec.RootFS{
+			Type: "layers",
+		},
+	}
+
+	if err := json.Unmarshal([]byte(img.Amd64.Config), &full.Config); err != nil {
+		return nil, err
+	}
+
+	for _, l := range layers {
+		full.RootFS.DiffIDs = append(full.RootFS.DiffIDs, digest.Digest(l.diffID))
+	}
+
+	return json.Marshal(full)
+}
+
+// writeDockerArchive writes the image in the format produced by `docker save`
+func writeDockerArchive(w io.Writer, ref string, config []byte, layers []*pulledLayer) error {
+	tw := tar.NewWriter(w)
+
+	configName := digest.FromBytes(config).Encoded() + ".json"
+	if err := tarAddBytes(tw, configName, config); err != nil {
+		return err
+	}
+
+	manifest := []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}{{
+		Config:   configName,
+		RepoTags: []string{ref},
+	}}
+
+	for _, l := range layers {
+		name := path.Join(digest.Digest(l.diffID).Encoded(), "layer.tar")
+		if err := tarAddFile(tw, name, l.tempfile, l.size); err != nil {
+			return err
+		}
+
+		manifest[0].Layers = append(manifest[0].Layers, name)
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := tarAddBytes(tw, "manifest.json", manifestJSON); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// writeOCILayout writes the image as a tarred oci image layout
+func writeOCILayout(w io.Writer, ref string, config []byte, layers []*pulledLayer) error {
+	tw := tar.NewWriter(w)
+
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	if err := tarAddBytes(tw, ocispec.ImageLayoutFile, layout); err != nil {
+		return err
+	}
+
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+	}
+
+	if err := tarAddBytes(tw, ociBlobPath(manifest.Config.Digest), config); err != nil {
+		return err
+	}
+
+	// layers are stored uncompressed, so their digest is the diff id
+	for _, l := range layers {
+		d := digest.Digest(l.diffID)
+		if err := tarAddFile(tw, ociBlobPath(d), l.tempfile, l.size); err != nil {
+			return err
+		}
+
+		manifest.Layers = append(manifest.Layers, ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    d,
+			Size:      l.size,
+		})
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := tarAddBytes(tw, ociBlobPath(digest.FromBytes(manifestJSON)), manifestJSON); err != nil {
+		return err
+	}
+
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []ocispec.Descriptor{{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    digest.FromBytes(manifestJSON),
+			Size:      int64(len(manifestJSON)),
+			Annotations: map[string]string{
+				ocispec.AnnotationRefName: ref,
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tarAddBytes(tw, "index.json", index); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func ociBlobPath(d digest.Digest) string {
+	return path.Join("blobs", d.Algorithm().String(), d.Encoded())
+}
+
+func tarAddBytes(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	return err
+}
+
+func tarAddFile(tw *tar.Writer, name string, file string, size int64) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: size,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
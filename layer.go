@@ -1,10 +1,21 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/fatih/color"
+	"github.com/k0kubun/go-ansi"
+	"github.com/kraudcloud/cli/api"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 func layersCMD() *cobra.Command {
@@ -15,6 +26,9 @@ func layersCMD() *cobra.Command {
 	}
 
 	c.AddCommand(layersLs())
+	c.AddCommand(layersGC())
+	c.AddCommand(layersVerify())
+	c.AddCommand(layersRepair())
 
 	return c
 }
@@ -47,3 +61,335 @@ func layersLs() *cobra.Command {
 
 	return c
 }
+
+func layersGC() *cobra.Command {
+	dryRun := false
+	minAge := time.Duration(0)
+
+	c := &cobra.Command{
+		Use:   "gc",
+		Short: "Find layers that are not referenced by any image",
+		Long: `Find layers that are not referenced by any image, and how much space they take.
+
+The kraud api has no route to delete layers yet, so gc only works with --dry-run,
+which lists the layers gc would delete.
+
+Layers are uploaded before the image referencing them is created, so a layer of a push
+that is still in progress is unreferenced for a while. With --min-age, gc waits and only
+lists the layers that are still unreferenced after that time.`,
+		Args: cobra.ExactArgs(0),
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !dryRun {
+				fmt.Fprintln(cmd.ErrOrStderr(), "the kraud api can't delete layers yet, use --dry-run to list the unreferenced layers")
+				os.Exit(1)
+			}
+
+			unused, err := unreferencedLayers(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+				os.Exit(1)
+			}
+
+			if len(unused) > 0 && minAge > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "waiting %s for layers to stay unreferenced\n", minAge)
+
+				select {
+				case <-cmd.Context().Done():
+					return nil
+				case <-time.After(minAge):
+				}
+
+				again, err := unreferencedLayers(cmd.Context())
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+					os.Exit(1)
+				}
+
+				unused = slices.DeleteFunc(unused, func(l api.KraudLayer) bool {
+					return !slices.ContainsFunc(again, func(a api.KraudLayer) bool { return a.ID == l.ID })
+				})
+			}
+
+			if len(unused) == 0 {
+				fmt.Fprintln(cmd.ErrOrStderr(), "no unreferenced layers")
+				return nil
+			}
+
+			size := uint64(0)
+			table := NewTable("ID", "Size", "OciID")
+			for _, i := range unused {
+				size += i.Size
+				table.AddRow(i.ID, humanize.Bytes(i.Size), i.OciID)
+			}
+			table.Print()
+
+			fmt.Fprintf(cmd.OutOrStdout(), "\n%d layers (%s) would be deleted\n", len(unused), humanize.Bytes(size))
+			return nil
+		},
+	}
+
+	c.Flags().BoolVar(&dryRun, "dry-run", dryRun, "only list the layers that would be deleted")
+	c.Flags().DurationVar(&minAge, "min-age", minAge, "wait this long and only list layers that stay unreferenced")
+
+	return c
+}
+
+// unreferencedLayers returns the layers no image refers to
+func unreferencedLayers(ctx context.Context) ([]api.KraudLayer, error) {
+	ls, err := API().ListLayers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var unused []api.KraudLayer
+	for _, i := range ls.Items {
+		if i.Refcount == 0 {
+			unused = append(unused, i)
+		}
+	}
+
+	return unused, nil
+}
+
+func layersVerify() *cobra.Command {
+	var progressMode string
+
+	c := &cobra.Command{
+		Use:   "verify [ID ...]",
+		Short: "Download layers and check them against their recorded digests",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newProgress(progressMode, cmd.OutOrStdout(), ansi.NewAnsiStderr())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
+			}
+
+			ls, err := API().ListLayers(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+				return nil
+			}
+
+			var toVerify []api.KraudLayer
+			total := uint64(0)
+			for _, i := range ls.Items {
+				if len(args) > 0 && !slices.Contains(args, i.ID) && !slices.Contains(args, i.OciID) {
+					continue
+				}
+
+				toVerify = append(toVerify, i)
+				if !i.Lost {
+					total += i.Size
+				}
+			}
+
+			p.Event(progressEvent{Phase: "verify", Status: statusStart, Message: "Verifying layers", Total: int64(total)})
+
+			failed := 0
+			table := NewTable("ID", "Size", "OciID", "Status")
+			for _, i := range toVerify {
+				status := color.GreenString("ok")
+
+				err := layerVerify(cmd.Context(), i, p)
+				if err != nil {
+					failed++
+					status = color.RedString(err.Error())
+					p.Event(progressEvent{Phase: "verify", Status: statusError, Layer: i.ID, Error: fmt.Sprintf("%s: %v", i.ID, err)})
+				} else {
+					p.Event(progressEvent{Phase: "verify", Status: statusInfo, Layer: i.ID, Message: fmt.Sprintf("%s ok", i.ID)})
+				}
+
+				table.AddRow(i.ID, humanize.Bytes(i.Size), i.OciID, status)
+			}
+
+			p.Event(progressEvent{Phase: "verify", Status: statusDone})
+
+			// the events are the output in json mode
+			if progressMode != progressJSON {
+				table.Print()
+			}
+
+			if failed > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "\n%d of %d layers failed verification\n", failed, len(toVerify))
+				os.Exit(1)
+			}
+
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&progressMode, "progress", progressAuto, "Progress output (auto, tty, plain, json)")
+
+	return c
+}
+
+// layerVerify downloads a layer and checks the stored blob against its sha256,
+// and the uncompressed tar against its diff id
+func layerVerify(ctx context.Context, l api.KraudLayer, p progressReporter) error {
+	if l.Lost {
+		return fmt.Errorf("lost")
+	}
+
+	blob, err := API().DownloadLayer(ctx, l.ID)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	counter := newProgressCounter(p, progressEvent{Phase: "verify", Layer: l.ID, Total: int64(l.Size)})
+	defer counter.Flush()
+
+	blobHasher := sha256.New()
+	reader := io.TeeReader(io.TeeReader(blob, blobHasher), counter)
+
+	layertar, err := decompressLayer(reader)
+	if err != nil {
+		return err
+	}
+	defer layertar.Close()
+
+	diffHasher := sha256.New()
+	if _, err := io.Copy(diffHasher, layertar); err != nil {
+		return err
+	}
+
+	// make sure the whole blob went through the hasher
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+
+	blobSum := fmt.Sprintf("%x", blobHasher.Sum(nil))
+	if want := strings.TrimPrefix(l.Sha256, "sha256:"); want != "" && want != blobSum {
+		return fmt.Errorf("sha256 mismatch: got %s", blobSum)
+	}
+
+	if diffID := fmt.Sprintf("sha256:%x", diffHasher.Sum(nil)); diffID != l.OciID {
+		return fmt.Errorf("diff id mismatch: got %s", diffID)
+	}
+
+	return nil
+}
+
+func layersRepair() *cobra.Command {
+	var compression string
+	compressionLevel := compressionLevelDefault
+	var progressMode string
+
+	c := &cobra.Command{
+		Use:   "repair <IMAGE ...>",
+		Short: "Re-upload lost layers from local docker images",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ls, err := API().ListLayers(cmd.Context())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error listing layers: %v\n", err)
+				return nil
+			}
+
+			lost := map[string]api.KraudLayer{}
+			for _, i := range ls.Items {
+				if i.Lost {
+					lost[i.OciID] = i
+				}
+			}
+
+			if len(lost) == 0 {
+				fmt.Fprintln(cmd.ErrOrStderr(), "no lost layers")
+				return nil
+			}
+
+			lc, err := newLayerCompression(compression, compressionLevel)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				return nil
+			}
+
+			p, err := newProgress(progressMode, cmd.OutOrStdout(), ansi.NewAnsiStderr())
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				return nil
+			}
+
+			for _, ref := range args {
+				if len(lost) == 0 {
+					break
+				}
+
+				err := layersRepairFrom(cmd.Context(), p, ref, lost, lc)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error repairing layers from %s: %v\n", ref, err)
+					os.Exit(1)
+				}
+			}
+
+			if len(lost) > 0 {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d lost layers were not found in the given images:\n", len(lost))
+				for ociID := range lost {
+					fmt.Fprintf(cmd.ErrOrStderr(), "  %s\n", ociID)
+				}
+				os.Exit(1)
+			}
+
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&compression, "compression", compressionPgzip, "Layer compression (gzip, pgzip, zstd), zstd needs a server that accepts zstd encoded layers")
+	c.Flags().IntVar(&compressionLevel, "compression-level", compressionLevel, "Compression level of the selected algorithm (-1 for default)")
+	c.Flags().StringVar(&progressMode, "progress", progressAuto, "Progress output (auto, tty, plain, json)")
+
+	return c
+}
+
+// layersRepairFrom uploads all layers of a local docker image that are in lost,
+// and removes them from lost
+func layersRepairFrom(ctx context.Context, p progressReporter, ref string, lost map[string]api.KraudLayer, lc layerCompression) error {
+	files, err := imageExtractFromDocker(ctx, p, ref, ref)
+	defer func() {
+		for _, t := range files {
+			os.Remove(t.tempfile)
+			os.Remove(t.zippedtempfile)
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]*extractedFileInfo)
+	for name, f := range files {
+		if _, ok := lost["sha256:"+f.hash]; ok {
+			found[name] = f
+		}
+	}
+
+	if len(found) == 0 {
+		return nil
+	}
+
+	err = zipLayers(p, ref, found, lc)
+	if err != nil {
+		return err
+	}
+
+	err = uploadLayers(p, ref, found, lc)
+	if err != nil {
+		return err
+	}
+
+	// a conflict means the kraud kept its lost copy, so the layer is still lost
+	var conflicts []string
+	for _, f := range found {
+		if f.existed {
+			conflicts = append(conflicts, "sha256:"+f.hash)
+			continue
+		}
+		delete(lost, "sha256:"+f.hash)
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("the kraud refused to replace lost layers %s", strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
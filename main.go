@@ -32,6 +32,7 @@ func main() {
 	root.AddCommand(imagesCMD())
 	root.AddCommand(layersCMD())
 	root.AddCommand(imagePushCMD())
+	root.AddCommand(buildCMD())
 	root.AddCommand(setupCMD())
 	root.AddCommand(eventsCMD())
 	root.AddCommand(tokenCMD())
@@ -41,6 +42,8 @@ func main() {
 	root.AddCommand(volumesCMD())
 	root.AddCommand(podLogs())
 	root.AddCommand(UpCMD())
+	root.AddCommand(DownCMD())
+	root.AddCommand(composeCMD())
 	root.AddCommand(namespacesCMD())
 	root.AddCommand(vpcsCMD())
 	root.AddCommand(vpcOverlaysCMD())
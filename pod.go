@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -87,21 +88,7 @@ func podsLsRun(cmd *cobra.Command, args []string) {
 				i.Namespace = i.Namespace[:18] + ".."
 			}
 
-			var status = "?"
-			if i.Status != nil {
-				status = i.Status.Display
-				if len(status) > len("Terminated") {
-					status = strings.Split(status, " ")[0]
-				}
-				if len(status) > len("Terminated") {
-					status = status[:len("Terminated")]
-				}
-				if i.Status.Healthy {
-					status = color.GreenString(status)
-				} else {
-					status = color.RedString(status)
-				}
-			}
+			status := podStatus(i)
 
 			if len(image) > 24 {
 				ss := strings.Split(image, "/")
@@ -125,6 +112,26 @@ func podsLsRun(cmd *cobra.Command, args []string) {
 	}
 }
 
+// podStatus is the short, colored status of a pod for tables
+func podStatus(pod api.KraudPod) string {
+	if pod.Status == nil {
+		return "?"
+	}
+
+	status := pod.Status.Display
+	if len(status) > len("Terminated") {
+		status = strings.Split(status, " ")[0]
+	}
+	if len(status) > len("Terminated") {
+		status = status[:len("Terminated")]
+	}
+
+	if pod.Status.Healthy {
+		return color.GreenString(status)
+	}
+	return color.RedString(status)
+}
+
 func podsInspect() *cobra.Command {
 	c := &cobra.Command{
 		Use:     "inspect",
@@ -260,37 +267,12 @@ func podLogs() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			ctx := cmd.Context()
 
-			dockerClient := API().DockerClient()
 			aid := completions.PodFromArg(ctx, API(), args[0])
-			c, err := dockerClient.ContainerInspect(ctx, aid)
-			if err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "error getting container: %v\n", err)
-				return nil
-			}
-
-			options := types.ContainerLogsOptions{
-				ShowStdout: true,
-				ShowStderr: true,
-				Follow:     follow,
-				Tail:       "all",
-			}
-			responseBody, err := dockerClient.ContainerLogs(ctx, c.ID, options)
+			err := containerLogs(ctx, aid, follow, "all", os.Stdout, os.Stderr)
 			if err != nil {
 				fmt.Fprintf(cmd.ErrOrStderr(), "error getting logs: %v\n", err)
 				return nil
 			}
-			defer responseBody.Close()
-
-			if c.Config.Tty {
-				_, err = io.Copy(os.Stdout, responseBody)
-			} else {
-				_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, responseBody)
-			}
-
-			if err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "error copying logs: %v\n", err)
-				return nil
-			}
 
 			return nil
 		},
@@ -302,6 +284,36 @@ func podLogs() *cobra.Command {
 
 }
 
+// containerLogs copies the logs of a container to stdout and stderr, or only stdout for a tty.
+// tail is the number of lines from the end of the logs, or "all"
+func containerLogs(ctx context.Context, aid string, follow bool, tail string, stdout, stderr io.Writer) error {
+	dockerClient := API().DockerClient()
+
+	c, err := dockerClient.ContainerInspect(ctx, aid)
+	if err != nil {
+		return err
+	}
+
+	responseBody, err := dockerClient.ContainerLogs(ctx, c.ID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+		Tail:       tail,
+	})
+	if err != nil {
+		return err
+	}
+	defer responseBody.Close()
+
+	if c.Config.Tty {
+		_, err = io.Copy(stdout, responseBody)
+	} else {
+		_, err = stdcopy.StdCopy(stdout, stderr, responseBody)
+	}
+
+	return err
+}
+
 func podSSH() *cobra.Command {
 	env := map[string]string{}
 	envFile := ""
@@ -326,7 +338,13 @@ func podSSH() *cobra.Command {
 				}
 				defer f.Close()
 
-				maps.Copy(out, envparser.EnvMapFromReader(f))
+				fileEnv, err := envparser.EnvMapFromReader(f)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error reading env file: %v\n", err)
+					return
+				}
+
+				maps.Copy(out, fileEnv)
 			}
 
 			maps.Copy(out, env)
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/colorstring"
+	"github.com/schollz/progressbar/v3"
+)
+
+const (
+	progressAuto  = "auto"
+	progressTTY   = "tty"
+	progressPlain = "plain"
+	progressJSON  = "json"
+)
+
+const (
+	statusStart    = "start"
+	statusProgress = "progress"
+	statusDone     = "done"
+	statusSkipped  = "skipped"
+	statusInfo     = "info"
+	statusError    = "error"
+)
+
+// progressEvent is a single step of a long running operation such as an image push
+type progressEvent struct {
+	Phase   string `json:"phase"`
+	Status  string `json:"status"`
+	Service string `json:"service,omitempty"`
+	Ref     string `json:"ref,omitempty"`
+	Layer   string `json:"layer,omitempty"`
+	Done    int64  `json:"done,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	AID     string `json:"aid,omitempty"`
+}
+
+// progressReporter consumes progress events. Implementations must be safe for concurrent use.
+type progressReporter interface {
+	Event(ev progressEvent)
+}
+
+// newProgress returns the reporter for a --progress mode.
+// results (such as image AIDs) go to stdout, everything else to stderr
+func newProgress(mode string, stdout, stderr io.Writer) (progressReporter, error) {
+	switch mode {
+	case progressAuto:
+		if f, ok := stdout.(*os.File); ok && isatty.IsTerminal(f.Fd()) {
+			return newBarProgress(stdout, stderr), nil
+		}
+		return &plainProgress{stdout: stdout, stderr: stderr}, nil
+	case progressTTY:
+		return newBarProgress(stdout, stderr), nil
+	case progressPlain:
+		return &plainProgress{stdout: stdout, stderr: stderr}, nil
+	case progressJSON:
+		return &jsonProgress{enc: json.NewEncoder(stdout)}, nil
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q (expected one of auto, tty, plain, json)", mode)
+	}
+}
+
+// jsonProgress writes every event as a line of json
+type jsonProgress struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (p *jsonProgress) Event(ev progressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.enc.Encode(ev)
+}
+
+// plainProgress writes a log line per phase and layer, suitable for CI logs
+type plainProgress struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (p *plainProgress) Event(ev progressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prefix := ""
+	if ev.Service != "" {
+		prefix = ev.Service + " "
+	}
+
+	switch ev.Status {
+	case statusStart, statusInfo:
+		if ev.Message != "" {
+			fmt.Fprintf(p.stderr, "%s%s\n", prefix, ev.Message)
+		}
+	case statusProgress:
+		if ev.Layer != "" && ev.Total > 0 && ev.Done >= ev.Total {
+			fmt.Fprintf(p.stderr, "%s%s %s done (%s)\n", prefix, ev.Phase, ev.Layer, humanize.Bytes(uint64(ev.Total)))
+		}
+	case statusSkipped:
+		fmt.Fprintf(p.stderr, "%s%s %s skipped: %s\n", prefix, ev.Phase, ev.Layer, ev.Message)
+	case statusError:
+		fmt.Fprintf(p.stderr, "%serror: %s\n", prefix, ev.Error)
+	}
+
+	if ev.AID != "" && ev.Status != statusInfo {
+		fmt.Fprintln(p.stdout, ev.AID)
+	}
+}
+
+// barProgress renders a progress bar per service and phase
+type barProgress struct {
+	mu     sync.Mutex
+	stdout io.Writer
+	stderr io.Writer
+	bars   map[string]*progressbar.ProgressBar
+	done   map[string]int64
+}
+
+func newBarProgress(stdout, stderr io.Writer) *barProgress {
+	return &barProgress{
+		stdout: stdout,
+		stderr: stderr,
+		bars:   map[string]*progressbar.ProgressBar{},
+		done:   map[string]int64{},
+	}
+}
+
+func (p *barProgress) Event(ev progressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := ev.Service + "/" + ev.Phase
+	prefix := ""
+	if ev.Service != "" {
+		prefix = "[cyan]" + ev.Service + "[reset] "
+	}
+
+	switch ev.Status {
+	case statusStart:
+		if ev.Total > 0 {
+			p.bars[key] = NewBar(int(ev.Total), prefix+ev.Message+" ")
+		} else if ev.Message != "" {
+			colorstring.Fprintln(p.stderr, prefix+ev.Message)
+		}
+
+	case statusProgress:
+		bar := p.bars[key]
+		if bar == nil {
+			return
+		}
+
+		layerKey := key + "/" + ev.Layer
+		bar.Add64(ev.Done - p.done[layerKey])
+		p.done[layerKey] = ev.Done
+
+	case statusDone:
+		if bar := p.bars[key]; bar != nil {
+			bar.Finish()
+			delete(p.bars, key)
+		}
+
+	case statusSkipped, statusInfo:
+		if ev.Message != "" && ev.Layer == "" {
+			colorstring.Fprintln(p.stderr, prefix+ev.Message)
+		}
+
+	case statusError:
+		colorstring.Fprintln(p.stderr, prefix+"[red]"+ev.Error+"[reset]")
+	}
+
+	if ev.AID != "" && ev.Status != statusInfo {
+		fmt.Fprintln(p.stdout, ev.AID)
+	}
+}
+
+// progressCounter is an io.Writer reporting the number of bytes written to it.
+// events are throttled, Flush reports the final count
+type progressCounter struct {
+	p    progressReporter
+	ev   progressEvent
+	last time.Time
+	sent int64
+}
+
+func newProgressCounter(p progressReporter, ev progressEvent) *progressCounter {
+	ev.Status = statusProgress
+	return &progressCounter{p: p, ev: ev}
+}
+
+func (pc *progressCounter) Write(b []byte) (int, error) {
+	pc.ev.Done += int64(len(b))
+
+	if pc.ev.Done >= pc.ev.Total || time.Since(pc.last) > 100*time.Millisecond {
+		pc.Flush()
+	}
+
+	return len(b), nil
+}
+
+func (pc *progressCounter) Flush() {
+	if pc.sent == pc.ev.Done {
+		return
+	}
+
+	pc.last = time.Now()
+	pc.sent = pc.ev.Done
+	pc.p.Event(pc.ev)
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kraudcloud/cli/api"
+	"github.com/kraudcloud/cli/completions"
+	"github.com/kraudcloud/cli/compose"
+	"github.com/kraudcloud/cli/compose/envparser"
+	"github.com/spf13/cobra"
+)
+
+const imageSignatureVersion = "kra.signature.v2"
+
+// imageSignatures is stored as the config of a layerless image next to the signed image,
+// the same way cosign stores signatures in a registry
+type imageSignatures struct {
+	Version    string           `json:"version"`
+	Digest     string           `json:"digest"`
+	Signatures []imageSignature `json:"signatures"`
+}
+
+type imageSignature struct {
+	KeyID     string `json:"keyid"`
+	Signature string `json:"sig"`
+}
+
+func imagesSign() *cobra.Command {
+	keyFile := ""
+
+	c := &cobra.Command{
+		Use:   "sign --key <key.pem> <image>",
+		Short: "Sign a remote image",
+		Long: `Sign the content digest of a remote image with an ed25519 key.
+
+The digest is computed from the config and the layers stored on the kraud,
+so a signature does not carry over to an image with different layers.
+
+The signature is stored on the kraud next to the image.
+A key can be created with
+   openssl genpkey -algorithm ed25519 -out key.pem
+   openssl pkey -in key.pem -pubout -out key.pub.pem`,
+		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := loadSigningKey(keyFile)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error loading key: %v\n", err)
+				return nil
+			}
+
+			img, err := API().InspectImage(cmd.Context(), args[0])
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error inspecting image: %v\n", err)
+				return nil
+			}
+
+			if img.Amd64 == nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "image %s has no amd64 variant\n", img.Ref)
+				return nil
+			}
+
+			digest, err := imageContentDigest(cmd.Context(), img.Amd64)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error computing image digest: %v\n", err)
+				return nil
+			}
+
+			sigs, _ := fetchImageSignatures(cmd.Context(), img.Ref, digest)
+
+			keyID := signingKeyID(key.Public().(ed25519.PublicKey))
+			sig := imageSignature{
+				KeyID:     keyID,
+				Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(digest))),
+			}
+
+			// replace an older signature by the same key
+			var kept []imageSignature
+			for _, s := range sigs.Signatures {
+				if s.KeyID != keyID {
+					kept = append(kept, s)
+				}
+			}
+			sigs.Signatures = append(kept, sig)
+
+			err = storeImageSignatures(cmd.Context(), img.Ref, sigs)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error storing signature: %v\n", err)
+				return nil
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "signed %s (%s) with key %s\n", img.Ref, digest, keyID)
+			return nil
+		},
+	}
+
+	c.Flags().StringVar(&keyFile, "key", keyFile, "PEM encoded ed25519 private key")
+	c.MarkFlagRequired("key")
+
+	return c
+}
+
+func imagesVerify() *cobra.Command {
+	trustedKeys := []string{}
+
+	c := &cobra.Command{
+		Use:   "verify <image>",
+		Short: "Verify the signature of a remote image",
+		Args:  cobra.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completions.ImageOptions(API(), cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			trusted, err := loadTrustedKeys(trustedKeys)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error loading trusted keys: %v\n", err)
+				os.Exit(1)
+			}
+
+			_, keyID, err := verifyImageSignature(cmd.Context(), args[0], trusted)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", args[0], err)
+				os.Exit(1)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: signed by %s\n", args[0], keyID)
+			return nil
+		},
+	}
+
+	c.Flags().StringSliceVar(&trustedKeys, "trusted-key", trustedKeys, "trusted public keys, files or directories of PEM files (default "+defaultTrustedKeysDir()+")")
+
+	return c
+}
+
+// verifyImageSignature checks that a remote image is signed by one of the trusted keys
+// and returns the verified image and the id of the key that signed it
+func verifyImageSignature(ctx context.Context, ref string, trusted []ed25519.PublicKey) (*api.KraudImageName, string, error) {
+	img, err := API().InspectImage(ctx, ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if img.Amd64 == nil {
+		return nil, "", fmt.Errorf("image has no amd64 variant")
+	}
+
+	digest, err := imageContentDigest(ctx, img.Amd64)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sigs, err := fetchImageSignatures(ctx, img.Ref, digest)
+	if err != nil {
+		return nil, "", fmt.Errorf("no signature found: %w", err)
+	}
+
+	if sigs.Digest != digest {
+		return nil, "", fmt.Errorf("signature is for %s, not %s", sigs.Digest, digest)
+	}
+
+	for _, s := range sigs.Signatures {
+		raw, err := base64.StdEncoding.DecodeString(s.Signature)
+		if err != nil {
+			continue
+		}
+
+		for _, key := range trusted {
+			if signingKeyID(key) == s.KeyID && ed25519.Verify(key, []byte(digest), raw) {
+				return img, s.KeyID, nil
+			}
+		}
+	}
+
+	return nil, "", fmt.Errorf("no valid signature from a trusted key")
+}
+
+// imageContentDigest is the digest that is signed for an image.
+// the OciID of an image is whatever the client that pushed it claimed, so instead the digest
+// covers the stored config and the diff ids of the stored layers the image references
+func imageContentDigest(ctx context.Context, img *api.KraudImage) (string, error) {
+	layers, err := API().ListLayers(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error listing layers: %w", err)
+	}
+
+	return contentDigest(img, layers)
+}
+
+func contentDigest(img *api.KraudImage, layers *api.KraudLayerList) (string, error) {
+	content := struct {
+		Config  string   `json:"config"`
+		DiffIDs []string `json:"diff_ids"`
+	}{
+		Config:  img.Config,
+		DiffIDs: []string{},
+	}
+
+	for _, ref := range img.Layers {
+		l := findLayer(layers, ref)
+		if l == nil {
+			return "", fmt.Errorf("image references a layer that does not exist")
+		}
+		content.DiffIDs = append(content.DiffIDs, l.OciID)
+	}
+
+	data, err := json.Marshal(content)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// imageSignatureRef is the image ref signatures for digest are stored under
+func imageSignatureRef(ref string, digest string) string {
+	return imageRepository(ref) + ":" + strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// isImageSignatureRef reports whether ref is where the signatures of an image are stored
+func isImageSignatureRef(ref string) bool {
+	tag := strings.TrimPrefix(ref, imageRepository(ref)+":")
+	return strings.HasPrefix(tag, "sha256-") && strings.HasSuffix(tag, ".sig")
+}
+
+func fetchImageSignatures(ctx context.Context, ref string, digest string) (*imageSignatures, error) {
+	sigs := &imageSignatures{
+		Version: imageSignatureVersion,
+		Digest:  digest,
+	}
+
+	img, err := API().InspectImage(ctx, imageSignatureRef(ref, digest))
+	if err != nil {
+		return sigs, err
+	}
+
+	if img.Amd64 == nil {
+		return sigs, fmt.Errorf("invalid signature image")
+	}
+
+	err = json.Unmarshal([]byte(img.Amd64.Config), sigs)
+	if err != nil {
+		return sigs, err
+	}
+
+	if sigs.Version != imageSignatureVersion {
+		return sigs, fmt.Errorf("unsupported signature version %q", sigs.Version)
+	}
+
+	return sigs, nil
+}
+
+func storeImageSignatures(ctx context.Context, ref string, sigs *imageSignatures) error {
+	config, err := json.Marshal(sigs)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := API().CreateImage(ctx, api.CreateImageJSONBody{
+		Ref:          imageSignatureRef(ref, sigs.Digest),
+		Config:       string(config),
+		OciID:        fmt.Sprintf("sha256:%x", sha256.Sum256(config)),
+		Architecture: "amd64",
+		Layers:       []api.KraudLayerReference{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the previous signature set is superseded
+	for _, rn := range rsp.Renamed {
+		API().DeleteImage(ctx, rn.Ref)
+	}
+
+	return nil
+}
+
+// signingKeyID identifies a public key by the digest of its PKIX encoding
+func signingKeyID(key ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(der))
+}
+
+func loadSigningKey(file string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", file)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	ed, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 key", file)
+	}
+
+	return ed, nil
+}
+
+func defaultTrustedKeysDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "kra", "trusted-keys")
+}
+
+// loadTrustedKeys loads PEM encoded public keys from files, or all *.pem files in directories.
+// without any paths the default trusted keys directory is used
+func loadTrustedKeys(paths []string) ([]ed25519.PublicKey, error) {
+	if len(paths) == 0 {
+		paths = []string{defaultTrustedKeysDir()}
+	}
+
+	var files []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(p, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	var keys []ed25519.PublicKey
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			var key crypto.PublicKey
+
+			switch block.Type {
+			case "PUBLIC KEY":
+				key, err = x509.ParsePKIXPublicKey(block.Bytes)
+			case "PRIVATE KEY":
+				var priv any
+				priv, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+				if signer, ok := priv.(crypto.Signer); ok {
+					key = signer.Public()
+				}
+			default:
+				continue
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+
+			if ed, ok := key.(ed25519.PublicKey); ok {
+				keys = append(keys, ed)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no trusted ed25519 keys found in " + strings.Join(paths, ", "))
+	}
+
+	return keys, nil
+}
+
+// verifyComposeSignatures checks that the images of all services in a compose template
+// are signed by one of the trusted keys. it returns the verified images of the services as repo@id,
+// so that tags moving after the check don't change what is launched
+func verifyComposeSignatures(ctx context.Context, template []byte, env map[string]string, trustedKeys []string) (map[string]string, error) {
+	trusted, err := loadTrustedKeys(trustedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	interpolated, err := envparser.Interpolate(template, env)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := compose.Parse(bytes.NewReader(interpolated))
+	if err != nil {
+		return nil, err
+	}
+
+	pinned := map[string]string{}
+	var failed []string
+	for name, s := range spec.Services {
+		ref := s.Image
+		if ref == "" {
+			failed = append(failed, fmt.Sprintf("  %s: no image", name))
+			continue
+		}
+
+		img, _, err := verifyImageSignature(ctx, ref, trusted)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("  %s (%s): %v", name, ref, err))
+			continue
+		}
+
+		pinned[name] = imageRepository(ref) + "@" + img.Amd64.OciID
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return nil, fmt.Errorf("refusing to launch services without a valid signature:\n%s", strings.Join(failed, "\n"))
+	}
+
+	return pinned, nil
+}
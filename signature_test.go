@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kraudcloud/cli/api"
+)
+
+func TestContentDigest(t *testing.T) {
+	layers := &api.KraudLayerList{Items: []api.KraudLayer{
+		{ID: "l1", OciID: "sha256:aaaa"},
+		{ID: "l2", OciID: "sha256:bbbb"},
+	}}
+
+	byID := func(id string) api.KraudLayerReference { return api.KraudLayerReference{ID: &id} }
+	byOciID := func(id string) api.KraudLayerReference { return api.KraudLayerReference{OciID: &id} }
+
+	img := &api.KraudImage{
+		Config: `{"Cmd":["sh"]}`,
+		OciID:  "sha256:cccc",
+		Layers: []api.KraudLayerReference{byID("l1"), byOciID("sha256:bbbb")},
+	}
+
+	digest, err := contentDigest(img, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the claimed OciID is not part of the digest
+	same := *img
+	same.OciID = "sha256:dddd"
+	if d, _ := contentDigest(&same, layers); d != digest {
+		t.Errorf("digest changed with the OciID: %s != %s", d, digest)
+	}
+
+	changed := map[string]*api.KraudImage{
+		"config":      {Config: `{"Cmd":["bash"]}`, Layers: img.Layers},
+		"layers":      {Config: img.Config, Layers: []api.KraudLayerReference{byID("l1")}},
+		"layer order": {Config: img.Config, Layers: []api.KraudLayerReference{byID("l2"), byID("l1")}},
+		"other layer": {Config: img.Config, Layers: []api.KraudLayerReference{byID("l1"), byID("l1")}},
+	}
+
+	for name, other := range changed {
+		d, err := contentDigest(other, layers)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if d == digest {
+			t.Errorf("%s: digest did not change", name)
+		}
+	}
+
+	if _, err := contentDigest(&api.KraudImage{Layers: []api.KraudLayerReference{byID("l3")}}, layers); err == nil {
+		t.Errorf("expected an error for a missing layer")
+	}
+}
+
+func TestIsImageSignatureRef(t *testing.T) {
+	for ref, want := range map[string]bool{
+		imageSignatureRef("registry:5000/web:v1", "sha256:abcd"): true,
+		"web:sha256-abcd.sig":    true,
+		"web:v1":                 false,
+		"web:v1.sig":             false,
+		"web@sha256:abcd":        false,
+		"registry:5000/web":      false,
+		"sha256-abcd.sig/web:v1": false,
+	} {
+		if got := isImageSignatureRef(ref); got != want {
+			t.Errorf("isImageSignatureRef(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
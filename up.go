@@ -2,78 +2,111 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/fatih/color"
+	"github.com/k0kubun/go-ansi"
 	"github.com/kraudcloud/cli/api"
+	"github.com/kraudcloud/cli/compose"
 	"github.com/kraudcloud/cli/compose/envparser"
 	"github.com/mitchellh/colorstring"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
 )
 
 func UpCMD() *cobra.Command {
-	cwd, _ := os.Getwd()
-	cwd = filepath.Base(cwd)
-	file := dockerComposeFile()
-
-	namespace := cwd
-	if namespace == "." {
-		namespace = ""
-	}
-
+	files := []string{}
+	namespace := composeProjectName()
 	env := map[string]string{}
 	envFile := ".env"
+	envSources := []string{}
 	verbose := 0
+	verifySignatures := false
+	validate := false
+	plan := false
+	push := false
+	build := false
+	progressMode := progressAuto
+	wait := false
+	explainEnv := false
+	strict := false
+	timeout := 5 * time.Minute
+	profiles := strings.FieldsFunc(os.Getenv("COMPOSE_PROFILES"), func(r rune) bool { return r == ',' })
+	trustedKeys := []string{}
 
 	c := &cobra.Command{
-		Use:   "up",
+		Use:   "up [SERVICE...]",
 		Short: "run an application",
+		Long: `Run an application from a docker-compose file.
+
+Only services without profiles and services with one of the active --profile are launched,
+or only the given services. Services they depend on are always launched as well.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if namespace == "" {
 				fmt.Fprintf(cmd.ErrOrStderr(), "namespace is required\n")
 				return nil
 			}
 
-			template, err := os.ReadFile(file)
+			files, template, err := loadComposeTemplate(files)
 			if err != nil {
 				fmt.Fprintf(cmd.ErrOrStderr(), "error reading docker-compose file: %v\n", err)
 				return err
 			}
 
-			// needed env neededVars
-			neededVars, err := envparser.ParseTemplateVars(bytes.NewReader(template))
+			neededVars, loaded, err := loadComposeEnv(cmd.ErrOrStderr(), template, env, envFile, envSources, strict)
 			if err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "error getting needed env vars: %v\n", err)
-				return err
+				fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+				os.Exit(1)
 			}
 
-			loaders := []envparser.EnvLoader{
-				envparser.LoadKV(env),
-				envparser.LoadKVs(os.Environ()),
+			if explainEnv {
+				printEnvExplain(cmd.OutOrStdout(), loaded)
+				return nil
 			}
 
-			// load env vars from file
-			if envFile != "" {
-				f, err := os.Open(envFile)
-				switch {
-				case os.IsNotExist(err):
-					break
-				case err != nil:
-					fmt.Fprintf(cmd.ErrOrStderr(), "error reading env file: %v\n", err)
-					return nil
+			env := loaded.Values()
+
+			if validate {
+				issues := lintCompose(cmd.Context(), files, env, true)
+				printComposeIssues(cmd.ErrOrStderr(), files, issues)
+				if compose.HasErrors(issues) {
+					os.Exit(1)
+				}
+			}
+
+			if plan {
+				changes, err := planCompose(cmd.Context(), namespace, template, env, profiles, args)
+				if err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "error planning: %v\n", err)
+					os.Exit(1)
 				}
 
-				defer f.Close()
+				switch OUTPUT_FORMAT {
+				case "json":
+					if changes == nil {
+						changes = []compose.Change{}
+					}
+					identJSONEncoder(cmd.OutOrStdout(), changes)
+				default:
+					printComposePlan(cmd.OutOrStdout(), changes)
+				}
 
-				loaders = append(loaders, envparser.LoadEnvReader(f))
+				if len(changes) > 0 {
+					os.Exit(2)
+				}
+				return nil
 			}
 
-			// load env vars
-			env, err := envparser.LoadEnv(neededVars, loaders...)
+			template, err = selectComposeServices(template, env, profiles, args)
 			if err != nil {
-				fmt.Fprintf(cmd.ErrOrStderr(), "error loading env vars: %v\n", err)
-				return nil
+				fmt.Fprintf(cmd.ErrOrStderr(), "error selecting services: %v\n", err)
+				os.Exit(1)
 			}
 
 			if verbose > 0 {
@@ -87,46 +120,432 @@ func UpCMD() *cobra.Command {
 				}
 
 				fmt.Fprintf(cmd.ErrOrStderr(), "env vars:\n")
-				for k, v := range env {
+				for k, v := range maskSecretEnv(loaded, nil) {
 					fmt.Fprintf(cmd.ErrOrStderr(), "  %s=%s\n", k, v)
 				}
 			}
 
+			if push || build {
+				err = upPush(cmd, template, env, progressMode, build)
+				if err != nil {
+					colorstring.Fprintf(cmd.ErrOrStderr(), "[red]error pushing images: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			if verifySignatures {
+				pinned, err := verifyComposeSignatures(cmd.Context(), template, env, trustedKeys)
+				if err != nil {
+					colorstring.Fprintf(cmd.ErrOrStderr(), "[red]%v\n", err)
+					os.Exit(1)
+				}
+
+				// launch exactly the images that were verified
+				template, err = compose.SetServiceImages(template, pinned)
+				if err != nil {
+					colorstring.Fprintf(cmd.ErrOrStderr(), "[red]error pinning verified images: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
 			detach, _ := cmd.Flags().GetBool("detach")
 
-			err = API().Launch(cmd.Context(), api.LaunchParams{
+			lp := api.LaunchParams{
 				Template:  string(template),
 				Env:       env,
 				Namespace: namespace,
 				Detach:    detach,
-			}, cmd.OutOrStdout())
+			}
+
+			if !wait {
+				err = API().Launch(cmd.Context(), lp, cmd.OutOrStdout())
+				if err != nil {
+					colorstring.Fprintf(cmd.ErrOrStderr(), "[red]%v\n", err)
+					os.Exit(1)
+				}
+				return nil
+			}
+
+			// the launched deployments are only reported when attached to the launch
+			launch, err := API().StartLaunch(cmd.Context(), lp)
+			if err != nil {
+				colorstring.Fprintf(cmd.ErrOrStderr(), "[red]%v\n", err)
+				os.Exit(1)
+			}
+
+			var launchOutput io.Writer = cmd.OutOrStdout()
+			if detach {
+				launchOutput = io.Discard
+			}
+
+			aids, err := API().LaunchAttachDeployments(cmd.Context(), launchOutput, launch.LaunchID)
+			if err != nil {
+				colorstring.Fprintf(cmd.ErrOrStderr(), "[red]%v\n", err)
+				os.Exit(1)
+			}
+
+			err = upWait(cmd.Context(), cmd.ErrOrStderr(), namespace, aids, timeout)
 			if err != nil {
 				colorstring.Fprintf(cmd.ErrOrStderr(), "[red]%v\n", err)
 				os.Exit(1)
-				return nil
 			}
 
 			return nil
 		},
 	}
 
-	c.Flags().StringVarP(&file, "file", "f", file, "docker-compose file to use")
+	c.Flags().StringSliceVarP(&files, "file", "f", files, composeFilesUsage)
 	c.Flags().StringVarP(&namespace, "namespace", "n", namespace, "namespace to use")
 	c.Flags().BoolP("detach", "d", false, "detach from the application")
 	c.Flags().StringToStringVarP(&env, "env", "e", env, "set environment variables")
 	c.Flags().StringVar(&envFile, "env-file", envFile, "set environment variables from a file")
+	c.Flags().StringArrayVar(&envSources, "env-source", envSources, envSourceUsage)
+	c.Flags().BoolVar(&explainEnv, "explain-env", explainEnv, "only show where each variable is loaded from, with secret values masked")
+	c.Flags().BoolVar(&strict, "strict", strict, "fail on variables without a value or default, and on unused variables in the env file")
 	c.Flags().CountVarP(&verbose, "verbose", "v", "verbose output")
+	c.Flags().StringSliceVar(&profiles, "profile", profiles, "enable services of these profiles, * for all (default $COMPOSE_PROFILES)")
+	c.Flags().BoolVar(&validate, "validate", validate, "check the compose file locally before launching")
+	c.Flags().BoolVar(&plan, "plan", plan, "only show what would change in the namespace, exits with 2 if anything would")
+	c.Flags().BoolVar(&push, "push", push, "push images that are newer locally before launching")
+	c.Flags().BoolVar(&build, "build", build, "build services with a build section locally and push them before launching")
+	c.Flags().StringVar(&progressMode, "progress", progressMode, "Progress output of --push and --build (auto, tty, plain, json)")
+	c.Flags().BoolVar(&wait, "wait", wait, "wait until all launched pods are healthy")
+	c.Flags().DurationVar(&timeout, "timeout", timeout, "how long --wait waits for pods to become healthy")
+	c.Flags().BoolVar(&verifySignatures, "verify-signatures", verifySignatures, "refuse to launch services whose images are not signed by a trusted key, and launch the verified images by their id")
+	c.Flags().StringSliceVar(&trustedKeys, "trusted-key", trustedKeys, "trusted public keys for --verify-signatures, files or directories of PEM files")
 	return c
 }
 
-func dockerComposeFile() string {
-	if _, err := os.Stat("docker-compose.yml"); err == nil {
-		return "docker-compose.yml"
+// composeProjectName is the namespace a project is launched in, named after the working directory
+func composeProjectName() string {
+	cwd, _ := os.Getwd()
+	cwd = filepath.Base(cwd)
+	if cwd == "." {
+		return ""
+	}
+
+	return cwd
+}
+
+// loadComposeEnv loads the variables used in a compose template from the --env flags,
+// the process environment, the --env-source stores and the env file, in that order of precedence.
+// variables defined with different values by several sources, referenced without a value or default,
+// or defined in the env file but never referenced are warned about on w. with strict, the latter two are errors
+func loadComposeEnv(w io.Writer, template []byte, env map[string]string, envFile string, sources []string, strict bool) (map[string]envparser.Variable, envparser.Env, error) {
+	neededVars, err := envparser.ParseTemplateVars(bytes.NewReader(template))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error getting needed env vars: %w", err)
+	}
+
+	envSources := []envparser.Source{
+		{Name: "--env", Load: envparser.LoadKV(env)},
+		{Name: "environment", Load: envparser.LoadKVs(os.Environ())},
+	}
+
+	for _, source := range sources {
+		loader, err := envSourceLoader(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		envSources = append(envSources, envparser.Source{Name: source, Load: loader})
+	}
+
+	// load env vars from file
+	var fileEnv map[string]string
+	if envFile != "" {
+		f, err := os.Open(envFile)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, nil, fmt.Errorf("error reading env file: %w", err)
+		default:
+			defer f.Close()
+
+			fileEnv, err = envparser.EnvMapFromReader(f)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error reading env file %s: %w", envFile, err)
+			}
+			envSources = append(envSources, envparser.Source{Name: envFile, Load: envparser.LoadKV(fileEnv)})
+		}
+	}
+
+	loaded, err := envparser.LoadEnv(neededVars, envSources...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading env vars: %w", err)
+	}
+
+	for _, name := range sortedKeys(loaded) {
+		v := loaded[name]
+		if len(v.Conflicts) > 0 {
+			colorstring.Fprintf(w, "[yellow]warning: %s is also set to a different value by %s, using the value from %s\n",
+				name, strings.Join(v.Conflicts, ", "), v.Source)
+		}
+	}
+
+	var problems []string
+	for _, name := range envparser.Undefined(neededVars, loaded) {
+		problems = append(problems, fmt.Sprintf("variable %s is not set and has no default, it will be empty", name))
+	}
+	for _, name := range envparser.Unused(neededVars, fileEnv) {
+		problems = append(problems, fmt.Sprintf("variable %s is set in %s but never used, is it misspelled?", name, envFile))
+	}
+
+	for _, problem := range problems {
+		if strict {
+			colorstring.Fprintf(w, "[red]error: %s\n", problem)
+		} else {
+			colorstring.Fprintf(w, "[yellow]warning: %s\n", problem)
+		}
+	}
+
+	if strict && len(problems) > 0 {
+		return nil, nil, fmt.Errorf("%d environment problems with --strict", len(problems))
+	}
+
+	return neededVars, loaded, nil
+}
+
+// printEnvExplain prints where each loaded variable came from, with secret values masked
+func printEnvExplain(w io.Writer, loaded envparser.Env) {
+	masked := maskSecretEnv(loaded, nil)
+
+	switch OUTPUT_FORMAT {
+	case "json":
+		explained := make(envparser.Env, len(loaded))
+		for k, v := range loaded {
+			v.Value = masked[k]
+			explained[k] = v
+		}
+		identJSONEncoder(w, explained)
+	default:
+		tbl := NewTable("name", "source", "value")
+		tbl.WithWriter(w)
+		for _, name := range sortedKeys(loaded) {
+			v := loaded[name]
+			source := v.Source
+			if len(v.Conflicts) > 0 {
+				source += color.YellowString(" (overrides %s)", strings.Join(v.Conflicts, ", "))
+			}
+			tbl.AddRow(name, source, masked[name])
+		}
+		tbl.Print()
+	}
+}
+
+const envSourceUsage = `load variables from a secret store, in order of precedence:
+keyring[:SCOPE]  the OS keyring, stored as env:NAME or env:SCOPE:NAME of the kraudcloud service
+pass:ENTRY       a .env formatted entry of the pass password store
+sops:FILE        a sops encrypted .env file
+exec:COMMAND     the .env formatted output of a shell command`
+
+// envSourceLoader returns the loader for an --env-source
+func envSourceLoader(source string) (envparser.EnvLoader, error) {
+	kind, arg, _ := strings.Cut(source, ":")
+
+	if kind != "keyring" && arg == "" {
+		return nil, fmt.Errorf("env source %s requires an argument, like %s:VALUE", kind, kind)
+	}
+
+	switch kind {
+	case "keyring":
+		return envparser.LoadKeyring(serviceName, arg), nil
+	case "pass":
+		return envparser.LoadPass(arg)
+	case "sops":
+		return envparser.LoadSops(arg)
+	case "exec":
+		return envparser.LoadExec(arg)
+	default:
+		return nil, fmt.Errorf("unknown env source %q (expected keyring, pass, sops or exec)", kind)
+	}
+}
+
+// selectComposeServices removes the services that are not selected by profiles or names from the template
+func selectComposeServices(template []byte, env map[string]string, profiles []string, names []string) ([]byte, error) {
+	interpolated, err := envparser.Interpolate(template, env)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := compose.Parse(bytes.NewReader(interpolated))
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := spec.SelectServices(profiles, names)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(selected) == len(spec.Services) {
+		return template, nil
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no services selected, enable a profile with --profile")
+	}
+
+	return compose.FilterServices(template, selected)
+}
+
+// upPush pushes the images of the services in template, reporting progress to stderr
+func upPush(cmd *cobra.Command, template []byte, env map[string]string, progressMode string, build bool) error {
+	p, err := newProgress(progressMode, cmd.ErrOrStderr(), ansi.NewAnsiStderr())
+	if err != nil {
+		return err
+	}
+
+	interpolated, err := envparser.Interpolate(template, env)
+	if err != nil {
+		return err
+	}
+
+	spec, err := compose.Parse(bytes.NewReader(interpolated))
+	if err != nil {
+		return err
+	}
+
+	return upPushImages(cmd.Context(), p, spec, build)
+}
+
+// planCompose compares the selected services of a template with what is deployed in the namespace
+func planCompose(ctx context.Context, namespace string, template []byte, env map[string]string, profiles []string, names []string) ([]compose.Change, error) {
+	interpolated, err := envparser.Interpolate(template, env)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := compose.Parse(bytes.NewReader(interpolated))
+	if err != nil {
+		return nil, err
+	}
+
+	selected, err := spec.SelectServices(profiles, names)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := API().ListNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// nothing is deployed in a namespace that doesn't exist yet
+	exists := slices.ContainsFunc(namespaces.Items, func(ns api.K8sNamespace) bool {
+		return ns.Metadata.Name != nil && *ns.Metadata.Name == namespace
+	})
+	if !exists {
+		return spec.Plan(selected, nil), nil
+	}
+
+	deployments, err := composeProjectDeployments(ctx, namespace, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	deployed := make([]compose.Deployed, 0, len(deployments))
+	for _, deployment := range deployments {
+		d := compose.Deployed{Name: deployment.Name}
+		if len(deployment.Pods) > 0 {
+			pod := deployment.Pods[0]
+			d.CPU, d.Memory = pod.CPU, pod.Mem
+			if len(pod.Containers) > 0 {
+				d.Image = pod.Containers[0].ImageName
+				d.Env = map[string]string{}
+				for k, v := range pod.Containers[0].Env.AdditionalProperties {
+					d.Env[k] = v
+				}
+			}
+		}
+		deployed = append(deployed, d)
+	}
+
+	return spec.Plan(selected, deployed), nil
+}
+
+func printComposePlan(w io.Writer, changes []compose.Change) {
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "no changes\n")
+		return
+	}
+
+	counts := map[compose.Action]int{}
+	for _, change := range changes {
+		counts[change.Action]++
+
+		switch change.Action {
+		case compose.ActionCreate:
+			colorstring.Fprintf(w, "[green]+ %s\n", change.Service)
+		case compose.ActionReplace:
+			colorstring.Fprintf(w, "[yellow]~ %s[reset]: %s\n", change.Service, strings.Join(change.Reasons, ", "))
+		case compose.ActionRemove:
+			colorstring.Fprintf(w, "[red]- %s\n", change.Service)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d to create, %d to replace, %d to remove\n", counts[compose.ActionCreate], counts[compose.ActionReplace], counts[compose.ActionRemove])
+}
+
+const composeFilesUsage = "docker-compose files to use, later files override earlier ones (default compose.yaml or docker-compose.yml and their .override file)"
+
+// composeFiles returns the compose files to use.
+// without any given files, the compose file in the working directory and its override file are used
+func composeFiles(files []string) ([]string, error) {
+	if len(files) > 0 {
+		return files, nil
+	}
+
+	for _, name := range []string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"} {
+		if _, err := os.Stat(name); err != nil {
+			continue
+		}
+
+		files = []string{name}
+
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		for _, override := range []string{base + ".override.yml", base + ".override.yaml"} {
+			if _, err := os.Stat(override); err == nil {
+				files = append(files, override)
+				break
+			}
+		}
+
+		return files, nil
+	}
+
+	return nil, fmt.Errorf("no compose.yaml or docker-compose.yml found")
+}
+
+// readComposeFiles reads the contents of the compose files
+func readComposeFiles(files []string) ([][]byte, error) {
+	var docs [][]byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, data)
+	}
+
+	return docs, nil
+}
+
+// loadComposeTemplate reads the compose files and merges them into a single template
+func loadComposeTemplate(files []string) ([]string, []byte, error) {
+	files, err := composeFiles(files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	docs, err := readComposeFiles(files)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if _, err := os.Stat("docker-compose.yaml"); err == nil {
-		return "docker-compose.yaml"
+	template, err := compose.Merge(docs...)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return ""
+	return files, template, nil
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/kraudcloud/cli/compose"
+)
+
+// upPushImages pushes the images of all services in spec that are newer locally than on the kraud.
+// services without a local image are skipped.
+// with build, services with a build section are built locally first
+func upPushImages(ctx context.Context, p progressReporter, spec *compose.File, build bool) error {
+	lc := layerCompression{Algo: compressionPgzip, Level: compressionLevelDefault}
+
+	docker, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		return err
+	}
+	defer docker.Close()
+
+	pushed := map[string]bool{}
+	for _, name := range sortedKeys(spec.Services) {
+		s := spec.Services[name]
+
+		if build && s.Build != nil {
+			if s.Image == "" {
+				return fmt.Errorf("service %s: an image name is required to push the build", name)
+			}
+
+			err := upBuildImage(ctx, docker, p, name, s.Image, s.Build)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", name, err)
+			}
+		}
+
+		if s.Image == "" || pushed[s.Image] {
+			continue
+		}
+		pushed[s.Image] = true
+
+		// images that only exist remotely, like the ones pulled from a registry, are not pushed
+		_, _, err := docker.ImageInspectWithRaw(ctx, s.Image)
+		if dockerclient.IsErrNotFound(err) {
+			ev := progressEvent{Phase: "push", Service: name, Ref: s.Image, Skipped: true}
+			p.Event(withStatus(ev, statusSkipped, "Image "+s.Image+" not available locally, not pushing"))
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+
+		_, err = imagePush(ctx, docker, p, name, s.Image, lc, false)
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// upBuildImage builds the image of a service with the local docker
+func upBuildImage(ctx context.Context, docker *dockerclient.Client, p progressReporter, serviceName string, ref string, bc *compose.BuildConfig) error {
+	ev := progressEvent{Phase: "build", Service: serviceName, Ref: ref}
+	p.Event(withStatus(ev, statusStart, "Building image "+ref))
+
+	if bc.DockerfileInline != "" {
+		return fmt.Errorf("dockerfile_inline is not supported")
+	}
+
+	contextDir := bc.Context
+	if contextDir == "" {
+		contextDir = "."
+	}
+	if strings.Contains(contextDir, "://") {
+		return fmt.Errorf("remote build contexts are not supported")
+	}
+
+	dockerfile := bc.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	buildContext, err := buildContextArchive(contextDir, dockerfile)
+	if buildContext != nil {
+		defer os.Remove(buildContext.Name())
+		defer buildContext.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error creating build context: %w", err)
+	}
+
+	opts := types.ImageBuildOptions{
+		Tags:       []string{ref},
+		Dockerfile: filepath.ToSlash(dockerfile),
+		Target:     bc.Target,
+		Labels:     bc.Labels,
+		CacheFrom:  bc.CacheFrom,
+		Remove:     true,
+		BuildArgs:  map[string]*string(bc.Args),
+	}
+
+	rsp, err := docker.ImageBuild(ctx, buildContext, opts)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	dec := json.NewDecoder(rsp.Body)
+	for {
+		var msg jsonmessage.JSONMessage
+		err := dec.Decode(&msg)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		for _, line := range strings.Split(strings.TrimSpace(msg.Stream), "\n") {
+			if line != "" {
+				p.Event(withStatus(ev, statusInfo, line))
+			}
+		}
+	}
+
+	p.Event(withStatus(ev, statusDone, "Built image "+ref))
+	return nil
+}
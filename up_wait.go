@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kraudcloud/cli/api"
+	"github.com/mitchellh/colorstring"
+)
+
+// upWaitLogLines is how many lines of logs are printed for each failing pod
+const upWaitLogLines = 20
+
+// upWait waits until all pods of the launched deployments are healthy.
+// without deployment AIDs, all deployments in the namespace are waited for.
+// if a pod crashes or the timeout is reached, the last logs of the failing pods are printed to w
+func upWait(ctx context.Context, w io.Writer, namespace string, aids []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if len(aids) == 0 {
+		overview, err := API().NamespaceOverview(ctx, namespace)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range overview.Deployments {
+			aids = append(aids, d.AID)
+		}
+	}
+
+	status := map[string]string{}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var pending, crashed []api.KraudPod
+		var err error
+		for _, aid := range aids {
+			var deployment *api.KraudDeployment
+			deployment, err = API().InspectDeployment(ctx, aid)
+			if err != nil {
+				if ctx.Err() == nil {
+					return err
+				}
+				break
+			}
+
+			// deployments that aren't scheduled yet have no pods
+			if len(deployment.Pods) == 0 {
+				pending = append(pending, api.KraudPod{AID: aid, Name: deployment.Name})
+				continue
+			}
+
+			for _, pod := range deployment.Pods {
+				display := "?"
+				if pod.Status != nil {
+					display = pod.Status.Display
+				}
+
+				if status[pod.AID] != display {
+					status[pod.AID] = display
+					fmt.Fprintf(w, "%s: %s\n", pod.Name, podStatus(pod))
+				}
+
+				switch {
+				case podCrashed(pod):
+					crashed = append(crashed, pod)
+				case pod.Status == nil || !pod.Status.Healthy:
+					pending = append(pending, pod)
+				}
+			}
+		}
+
+		if err == nil {
+			if len(crashed) > 0 {
+				upWaitLogs(w, crashed)
+				return fmt.Errorf("%d pods failed", len(crashed))
+			}
+
+			if len(pending) == 0 {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			upWaitLogs(w, pending)
+			return fmt.Errorf("timed out after %s waiting for %d pods to become healthy", timeout, len(pending))
+		case <-ticker.C:
+		}
+	}
+}
+
+// podCrashed reports whether a pod stopped, rather than just not being healthy yet
+func podCrashed(pod api.KraudPod) bool {
+	if pod.Status == nil {
+		return false
+	}
+
+	display := strings.ToLower(pod.Status.Display)
+	for _, s := range []string{"terminated", "exited", "error", "failed", "crash"} {
+		if strings.HasPrefix(display, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// upWaitLogs prints the last log lines of pods
+func upWaitLogs(w io.Writer, pods []api.KraudPod) {
+	// the wait context may be expired already
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, pod := range pods {
+		colorstring.Fprintf(w, "\n[red]%s[reset] last logs:\n", pod.Name)
+
+		var buf bytes.Buffer
+		err := containerLogs(ctx, pod.AID, false, fmt.Sprint(upWaitLogLines), &buf, &buf)
+		if err != nil {
+			fmt.Fprintf(w, "  error getting logs: %v\n", err)
+			continue
+		}
+
+		var lines []string
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		for _, line := range lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+}